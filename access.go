@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Jobs and uploads are private to whoever created them. Creating one
+// returns an owner token that later requests must send as a Bearer token;
+// it is never accepted in the URL, where it would end up in access logs
+// and Referer headers. Owners can mint signed download links, optionally
+// single-use, to share one output without sharing the token.
+
+// randomToken returns n random bytes encoded for use in URLs
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newOwnerToken returns a new owner token and the hash kept with the job
+func newOwnerToken() (string, [sha256.Size]byte) {
+	token := randomToken(32)
+	return token, sha256.Sum256([]byte(token))
+}
+
+// requestToken returns the owner token sent with a request
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return ""
+}
+
+// ownerMatches reports whether a request carries the token hashed to hash
+func ownerMatches(hash [sha256.Size]byte, r *http.Request) bool {
+	token := requestToken(r)
+	if token == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(sum[:], hash[:]) == 1
+}
+
+// isOwner reports whether a request carries the job's owner token
+func isOwner(entry *JobEntry, r *http.Request) bool {
+	return ownerMatches(entry.OwnerHash, r)
+}
+
+// processLinkKey signs download links when no download_secret is set.
+// Such links stop working on restart, as do the jobs they point to.
+var processLinkKey = []byte(randomToken(32))
+
+func linkKey() []byte {
+	if secret := cfg().DownloadSecret; secret != "" {
+		return []byte(secret)
+	}
+	return processLinkKey
+}
+
+// linkSignature signs the parameters of a download link. The nonce is
+// empty for links that can be used repeatedly.
+func linkSignature(id string, expires int64, nonce string) string {
+	mac := hmac.New(sha256.New, linkKey())
+	fmt.Fprintf(mac, "%s\n%d\n%s", id, expires, nonce)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedLinkValid checks the signature and expiry of a download link
+func signedLinkValid(q url.Values) bool {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	want := linkSignature(q.Get("id"), expires, q.Get("once"))
+	return hmac.Equal([]byte(want), []byte(q.Get("sig")))
+}
+
+// linkUsed reports whether a single-use link was already used
+func linkUsed(jobID, nonce string) bool {
+	jobStore.RLock()
+	defer jobStore.RUnlock()
+	entry, ok := jobStore.jobs[jobID]
+	return !ok || entry.UsedLinks[nonce]
+}
+
+// consumeLink marks a single-use link as used, reporting false when it
+// already was
+func consumeLink(jobID, nonce string) bool {
+	jobStore.Lock()
+	defer jobStore.Unlock()
+	entry, ok := jobStore.jobs[jobID]
+	if !ok || entry.UsedLinks[nonce] {
+		return false
+	}
+	if entry.UsedLinks == nil {
+		entry.UsedLinks = make(map[string]bool)
+	}
+	entry.UsedLinks[nonce] = true
+	return true
+}
+
+// releaseLink makes a consumed single-use link usable again after the
+// download it was consumed for did not complete
+func releaseLink(jobID, nonce string) {
+	jobStore.Lock()
+	defer jobStore.Unlock()
+	if entry, ok := jobStore.jobs[jobID]; ok {
+		delete(entry.UsedLinks, nonce)
+	}
+}
+
+// handleDownloadLink mints a signed download link for a finished job.
+// Parameters: id, ttl (seconds or a Go duration, capped by
+// download_link_ttl) and single_use=true.
+func handleDownloadLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.FormValue("id")
+	jobStore.RLock()
+	entry, exists := jobStore.jobs[jobID]
+	owner := exists && isOwner(entry, r)
+	done := exists && entry.Status == StatusDone
+	jobStore.RUnlock()
+
+	if !owner {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if !done {
+		http.Error(w, "Job not complete", http.StatusConflict)
+		return
+	}
+
+	ttl := cfg().DownloadLinkTTL.Std()
+	if v := r.FormValue("ttl"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = min(ttl, d.Std())
+	}
+	expires := time.Now().Add(ttl)
+
+	q := url.Values{}
+	q.Set("id", jobID)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	singleUse := r.FormValue("single_use") == "true"
+	if singleUse {
+		q.Set("once", randomToken(16))
+	}
+	q.Set("sig", linkSignature(jobID, expires.Unix(), q.Get("once")))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        "/api/download?" + q.Encode(),
+		"expires_at": expires.UTC().Format(time.RFC3339),
+		"single_use": singleUse,
+	})
+}
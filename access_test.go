@@ -0,0 +1,248 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/status?id=x&token=from-query", nil)
+	if got := requestToken(r); got != "" {
+		t.Errorf("token accepted from the query: %q", got)
+	}
+	r.Header.Set("Authorization", "Bearer from-header")
+	if got := requestToken(r); got != "from-header" {
+		t.Errorf("requestToken = %q, want from-header", got)
+	}
+	r.Header.Set("Authorization", "Basic abc")
+	if got := requestToken(r); got != "" {
+		t.Errorf("non-Bearer credentials accepted: %q", got)
+	}
+}
+
+func TestOwnerMatches(t *testing.T) {
+	token, hash := newOwnerToken()
+	other, _ := newOwnerToken()
+	tests := []struct {
+		auth string
+		want bool
+	}{
+		{"Bearer " + token, true},
+		{"Bearer " + other, false},
+		{"Bearer ", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		if got := ownerMatches(hash, r); got != tt.want {
+			t.Errorf("ownerMatches with %q = %v, want %v", tt.auth, got, tt.want)
+		}
+	}
+}
+
+// signedLink builds download link parameters as handleDownloadLink does
+func signedLink(id string, expires time.Time, nonce string) url.Values {
+	q := url.Values{}
+	q.Set("id", id)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	if nonce != "" {
+		q.Set("once", nonce)
+	}
+	q.Set("sig", linkSignature(id, expires.Unix(), nonce))
+	return q
+}
+
+func TestSignedLinkValid(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		mutate func(q url.Values)
+		want   bool
+	}{
+		{"valid", func(q url.Values) {}, true},
+		{"other job", func(q url.Values) { q.Set("id", "job-2") }, false},
+		{"extended expiry", func(q url.Values) { q.Set("expires", strconv.FormatInt(future.Add(time.Hour).Unix(), 10)) }, false},
+		{"tampered signature", func(q url.Values) { q.Set("sig", q.Get("sig")[1:]) }, false},
+		{"missing signature", func(q url.Values) { q.Del("sig") }, false},
+		{"added nonce", func(q url.Values) { q.Set("once", "abc") }, false},
+		{"bad expiry", func(q url.Values) { q.Set("expires", "soon") }, false},
+	}
+	for _, tt := range tests {
+		q := signedLink("job-1", future, "")
+		tt.mutate(q)
+		if got := signedLinkValid(q); got != tt.want {
+			t.Errorf("%s: signedLinkValid = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if signedLinkValid(signedLink("job-1", time.Now().Add(-time.Second), "")) {
+		t.Error("expired link accepted")
+	}
+	if !signedLinkValid(signedLink("job-1", future, "nonce")) {
+		t.Error("single-use link rejected")
+	}
+	q := signedLink("job-1", future, "nonce")
+	q.Del("once")
+	if signedLinkValid(q) {
+		t.Error("single-use link accepted without its nonce")
+	}
+}
+
+func TestSignedLinkSecret(t *testing.T) {
+	withConfig(t, func(c *Config) { c.DownloadSecret = "secret-1" })
+	q := signedLink("job-1", time.Now().Add(time.Hour), "")
+	withConfig(t, func(c *Config) { c.DownloadSecret = "secret-2" })
+	if signedLinkValid(q) {
+		t.Error("link signed with a previous secret accepted")
+	}
+}
+
+// addDownloadJob registers a finished job whose output holds content
+func addDownloadJob(t *testing.T, id, content string) *JobEntry {
+	t.Helper()
+	withConfig(t, func(c *Config) { c.WorkDir = t.TempDir() })
+	dir, err := createJobDir(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &JobEntry{Status: StatusDone, Key: newJobKey(), DownloadName: "out.txt", CreatedAt: time.Now()}
+	entry.OutputPath = filepath.Join(dir, "output.txt")
+	if err := encryptFrom(strings.NewReader(content), entry.OutputPath, entry.Key); err != nil {
+		t.Fatal(err)
+	}
+	jobStore.Lock()
+	jobStore.jobs[id] = entry
+	jobStore.Unlock()
+	t.Cleanup(func() {
+		jobStore.Lock()
+		delete(jobStore.jobs, id)
+		jobStore.Unlock()
+	})
+	return entry
+}
+
+func TestSingleUseLink(t *testing.T) {
+	const content = "converted document"
+	addDownloadJob(t, "link-job", content)
+	target := "/api/download?" + signedLink("link-job", time.Now().Add(time.Hour), "n1").Encode()
+
+	download := func(method string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handleDownload(w, r)
+		return w
+	}
+
+	// Previewers and download managers must not spend the link
+	if w := download("HEAD", nil); w.Code != http.StatusOK {
+		t.Fatalf("HEAD: status %d", w.Code)
+	}
+	if w := download("GET", http.Header{"Range": {"bytes=0-8"}}); w.Code != http.StatusPartialContent || w.Body.String() != content[:9] {
+		t.Fatalf("Range GET: status %d, body %q", w.Code, w.Body)
+	}
+	if w := download("GET", http.Header{"If-None-Match": {"*"}}); w.Code != http.StatusNotModified {
+		t.Fatalf("conditional GET: status %d", w.Code)
+	}
+
+	if w := download("GET", nil); w.Code != http.StatusOK || w.Body.String() != content {
+		t.Fatalf("full GET: status %d, body %q", w.Code, w.Body)
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		if w := download(method, nil); w.Code != http.StatusGone {
+			t.Errorf("%s after a full download: status %d, want 410", method, w.Code)
+		}
+	}
+}
+
+func TestDownloadRequiresOwner(t *testing.T) {
+	entry := addDownloadJob(t, "owned-job", "x")
+	token, hash := newOwnerToken()
+	entry.OwnerHash = hash
+
+	tests := []struct {
+		name, target, auth string
+		want               int
+	}{
+		{"no token", "/api/download?id=owned-job", "", http.StatusNotFound},
+		{"token in query", "/api/download?id=owned-job&token=" + token, "", http.StatusNotFound},
+		{"wrong token", "/api/download?id=owned-job", "Bearer nope", http.StatusNotFound},
+		{"owner", "/api/download?id=owned-job", "Bearer " + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		handleDownload(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestUploadRequiresOwner(t *testing.T) {
+	withConfig(t, func(c *Config) { c.WorkDir = t.TempDir() })
+
+	r := httptest.NewRequest("POST", "/api/uploads", nil)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Upload-Length", "0")
+	w := httptest.NewRecorder()
+	handleUploads(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d", w.Code)
+	}
+	location, token := w.Header().Get("Location"), w.Header().Get("X-Owner-Token")
+	if token == "" {
+		t.Fatal("no owner token issued")
+	}
+	id := strings.TrimPrefix(location, "/api/uploads/")
+	t.Cleanup(func() { removeUpload(id) })
+
+	for _, auth := range []string{"", "Bearer nope", "Bearer " + token} {
+		want := http.StatusNotFound
+		if auth == "Bearer "+token {
+			want = http.StatusOK
+		}
+		r := httptest.NewRequest("HEAD", location, nil)
+		r.Header.Set("Tus-Resumable", tusVersion)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handleUploads(w, r)
+		if w.Code != want {
+			t.Errorf("HEAD with %q: status %d, want %d", auth, w.Code, want)
+		}
+
+		// A stranger cannot delete it either
+		if want == http.StatusNotFound {
+			r := httptest.NewRequest("DELETE", location, nil)
+			r.Header.Set("Tus-Resumable", tusVersion)
+			r.Header.Set("Authorization", auth)
+			handleUploads(httptest.NewRecorder(), r)
+		}
+	}
+
+	dir := t.TempDir()
+	r = httptest.NewRequest("POST", "/api/convert", nil)
+	if _, err := claimUpload(id, r, dir, newJobKey()); err == nil {
+		t.Error("upload claimed without its owner token")
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	if _, err := claimUpload(id, r, dir, newJobKey()); err != nil {
+		t.Errorf("owner could not claim the upload: %v", err)
+	}
+}
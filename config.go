@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Config holds all runtime settings. Values come from the defaults below,
+// then the file named by CONFIG_FILE (JSON or YAML), then the environment.
+type Config struct {
+	Port              int               `json:"port"`
+	StaticDir         string            `json:"static_dir"`
+	TempDir           string            `json:"temp_dir"`
+	WorkDir           string            `json:"work_dir"`           // root of the per-job directories
+	DiskQuotaMB       int               `json:"disk_quota_mb"`      // 0 = unlimited
+	ScratchDir        string            `json:"scratch_dir"`        // plaintext work directories; empty picks /dev/shm when it is large enough
+	AllowDiskScratch  bool              `json:"allow_disk_scratch"` // let an unset scratch_dir fall back to temp_dir
+	MaxUploadMB       int               `json:"max_upload_mb"`
+	FormatLimitsMB    map[string]int    `json:"format_limits_mb"`     // per source format, overriding max_upload_mb
+	MaxArchiveEntryMB int               `json:"max_archive_entry_mb"` // largest decompressed file read from a spreadsheet
+	JobRetention      Duration          `json:"job_retention"`
+	UploadExpiry      Duration          `json:"upload_expiry"` // resumable uploads idle this long are deleted
+	CleanupInterval   Duration          `json:"cleanup_interval"`
+	ResponseGrace     Duration          `json:"response_grace"`  // wait beyond the job timeout
+	InlineDeadline    Duration          `json:"inline_deadline"` // wait for inline responses before falling back to the job
+	AdminToken        string            `json:"admin_token" secret:"true"`
+	DownloadSecret    string            `json:"download_secret" secret:"true"` // HMAC key of signed download links
+	DownloadLinkTTL   Duration          `json:"download_link_ttl"`             // longest lifetime of a signed link
+	Backend           string            `json:"backend"`
+	PDFEngines        []string          `json:"pdf_engines"`
+	MemoryReserveMB   int               `json:"memory_reserve_mb"`
+	Pools             PoolsConfig       `json:"pools"`
+	LibreOffice       LibreOfficeConfig `json:"libreoffice"`
+	Resources         ResourcePolicy    `json:"resources"`
+}
+
+// PoolsConfig sizes the worker pool of each job class
+type PoolsConfig struct {
+	Light  PoolConfig `json:"light"`
+	PDF    PoolConfig `json:"pdf"`
+	Office PoolConfig `json:"office"`
+}
+
+// PoolConfig sizes one worker pool
+type PoolConfig struct {
+	MinWorkers  int      `json:"min_workers"`
+	MaxWorkers  int      `json:"max_workers"`
+	Queue       int      `json:"queue"`
+	Timeout     Duration `json:"timeout"`
+	JobMemoryMB int      `json:"job_memory_mb"`
+}
+
+// LibreOfficeConfig controls the LibreOffice backend
+type LibreOfficeConfig struct {
+	Instances int      `json:"instances"`
+	Timeout   Duration `json:"timeout"`
+}
+
+// Duration accepts Go duration strings ("90s", "30m") or whole seconds
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(v * float64(time.Second)))
+		return nil
+	case string:
+		parsed, err := parseDuration(v)
+		*d = parsed
+		return err
+	}
+	return fmt.Errorf("invalid duration %s", data)
+}
+
+func parseDuration(s string) (Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return Duration(time.Duration(n) * time.Second), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return Duration(d), nil
+}
+
+// scratchHeadroom is how many times the largest upload the scratch
+// directory must hold: the decrypted input, the output and working files
+const scratchHeadroom = 3
+
+// maxUploadBytes is the largest input any source format accepts
+func (c *Config) maxUploadBytes() int64 {
+	mb := c.MaxUploadMB
+	for _, n := range c.FormatLimitsMB {
+		mb = max(mb, n)
+	}
+	return int64(mb) << 20
+}
+
+// resolveScratchDir picks /dev/shm when scratch_dir is unset and it has
+// room for the largest upload, so decrypted files never reach disk.
+// Container runtimes often give /dev/shm only 64 MB; the temp directory is
+// then used only with allow_disk_scratch, and otherwise scratch_dir stays
+// empty, which validate rejects. An explicit scratch_dir that is too small
+// is kept but logged, as conversions of large inputs will fail there.
+func (c *Config) resolveScratchDir() {
+	need := scratchHeadroom * c.maxUploadBytes()
+	if c.ScratchDir != "" {
+		if avail, ok := diskAvailable(c.ScratchDir); ok && avail < need {
+			log.Printf("scratch_dir %s has %d MB free; large conversions need %d MB", c.ScratchDir, avail>>20, need>>20)
+		}
+		return
+	}
+	if avail, ok := diskAvailable("/dev/shm"); ok && avail >= need {
+		c.ScratchDir = "/dev/shm"
+		return
+	}
+	if c.AllowDiskScratch {
+		c.ScratchDir = c.TempDir
+		log.Printf("/dev/shm is missing or below the %d MB large conversions need; decrypted files go to %s", need>>20, c.ScratchDir)
+	}
+}
+
+// defaultConfig returns the built-in settings
+func defaultConfig() *Config {
+	return &Config{
+		Port:              8080,
+		StaticDir:         "./static",
+		TempDir:           os.TempDir(),
+		WorkDir:           filepath.Join(os.TempDir(), "convertly-jobs"),
+		DiskQuotaMB:       1024,
+		MaxUploadMB:       32,
+		FormatLimitsMB:    map[string]int{"pdf": 64, "pptx": 64, "epub": 64},
+		MaxArchiveEntryMB: 128,
+		JobRetention:      Duration(30 * time.Minute),
+		UploadExpiry:      Duration(24 * time.Hour),
+		CleanupInterval:   Duration(10 * time.Minute),
+		ResponseGrace:     Duration(5 * time.Second),
+		InlineDeadline:    Duration(10 * time.Second),
+		DownloadLinkTTL:   Duration(time.Hour),
+		Backend:           BackendCLI,
+		PDFEngines:        append([]string{}, defaultPDFEnginePreference...),
+		MemoryReserveMB:   64,
+		Pools: PoolsConfig{
+			Light:  PoolConfig{MinWorkers: 2, MaxWorkers: defaultMaxWorkers(ClassLight), Queue: 256, Timeout: Duration(30 * time.Second), JobMemoryMB: 32},
+			PDF:    PoolConfig{MinWorkers: 1, MaxWorkers: defaultMaxWorkers(ClassPDF), Queue: 64, Timeout: Duration(120 * time.Second), JobMemoryMB: 256},
+			Office: PoolConfig{MinWorkers: 1, MaxWorkers: defaultMaxWorkers(ClassOffice), Queue: 32, Timeout: Duration(120 * time.Second), JobMemoryMB: 256},
+		},
+		LibreOffice: LibreOfficeConfig{Instances: 1, Timeout: Duration(45 * time.Second)},
+		Resources: ResourcePolicy{
+			MaxBytes: 5 << 20,
+			MaxCount: 20,
+			Timeout:  Duration(10 * time.Second),
+		},
+	}
+}
+
+// config is replaced as a whole on reload; read it through cfg
+var config atomic.Pointer[Config]
+
+// cfg returns the current configuration
+func cfg() *Config {
+	if c := config.Load(); c != nil {
+		return c
+	}
+	c := defaultConfig()
+	c.resolveScratchDir()
+	config.CompareAndSwap(nil, c)
+	return config.Load()
+}
+
+// initConfig loads and validates the configuration, exiting on errors
+func initConfig() {
+	c, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	config.Store(c)
+}
+
+// loadConfig builds a configuration from defaults, the config file and
+// the environment, and validates it
+func loadConfig() (*Config, error) {
+	c := defaultConfig()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+	c.resolveScratchDir()
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile overlays a JSON or YAML config file. Unknown keys are errors so
+// that typos don't silently fall back to defaults.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yaml" || ext == ".yml" {
+		if err := unmarshalYAML(data, c); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// envLoader overlays environment variables, collecting parse errors
+type envLoader struct {
+	errs []string
+}
+
+func (l *envLoader) str(name string, dst *string) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		*dst = v
+	}
+}
+
+func (l *envLoader) int(name string, dst *int) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Sprintf("%s: %q is not an integer", name, v))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (l *envLoader) int64(name string, dst *int64) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Sprintf("%s: %q is not an integer", name, v))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (l *envLoader) bool(name string, dst *bool) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Sprintf("%s: %q is not a boolean", name, v))
+			return
+		}
+		*dst = b
+	}
+}
+
+func (l *envLoader) duration(name string, dst *Duration) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		d, err := parseDuration(v)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		*dst = d
+	}
+}
+
+// list reads a comma-separated list
+func (l *envLoader) list(name string, dst *[]string) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
+
+// intMap reads comma-separated format=value pairs into dst, keeping keys
+// that are not mentioned
+func (l *envLoader) intMap(name string, dst *map[string]int) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		if *dst == nil {
+			*dst = make(map[string]int)
+		}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, value, _ := strings.Cut(item, "=")
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				l.errs = append(l.errs, fmt.Sprintf("%s: %q is not a name=integer pair", name, item))
+				continue
+			}
+			(*dst)[strings.TrimSpace(key)] = n
+		}
+	}
+}
+
+// loadEnv overlays environment variables onto the configuration
+func (c *Config) loadEnv() error {
+	l := &envLoader{}
+	l.int("PORT", &c.Port)
+	l.str("STATIC_DIR", &c.StaticDir)
+	l.str("TEMP_DIR", &c.TempDir)
+	l.str("WORK_DIR", &c.WorkDir)
+	l.int("DISK_QUOTA_MB", &c.DiskQuotaMB)
+	l.str("SCRATCH_DIR", &c.ScratchDir)
+	l.bool("ALLOW_DISK_SCRATCH", &c.AllowDiskScratch)
+	l.int("MAX_UPLOAD_MB", &c.MaxUploadMB)
+	l.intMap("FORMAT_LIMITS_MB", &c.FormatLimitsMB)
+	l.int("MAX_ARCHIVE_ENTRY_MB", &c.MaxArchiveEntryMB)
+	l.duration("JOB_RETENTION", &c.JobRetention)
+	l.duration("UPLOAD_EXPIRY", &c.UploadExpiry)
+	l.duration("CLEANUP_INTERVAL", &c.CleanupInterval)
+	l.duration("RESPONSE_GRACE", &c.ResponseGrace)
+	l.duration("INLINE_DEADLINE", &c.InlineDeadline)
+	l.str("ADMIN_TOKEN", &c.AdminToken)
+	l.str("DOWNLOAD_SECRET", &c.DownloadSecret)
+	l.duration("DOWNLOAD_LINK_TTL", &c.DownloadLinkTTL)
+	l.str("PANDOC_BACKEND", &c.Backend)
+	l.list("PDF_ENGINES", &c.PDFEngines)
+	l.int("MEMORY_RESERVE_MB", &c.MemoryReserveMB)
+
+	for _, class := range []string{ClassLight, ClassPDF, ClassOffice} {
+		p := c.Pools.get(class)
+		prefix := "POOL_" + strings.ToUpper(class) + "_"
+		l.int(prefix+"MIN_WORKERS", &p.MinWorkers)
+		l.int(prefix+"MAX_WORKERS", &p.MaxWorkers)
+		l.int(prefix+"QUEUE", &p.Queue)
+		l.duration(prefix+"TIMEOUT", &p.Timeout)
+		l.int(prefix+"JOB_MEMORY_MB", &p.JobMemoryMB)
+	}
+
+	l.int("LIBREOFFICE_INSTANCES", &c.LibreOffice.Instances)
+	l.duration("LIBREOFFICE_TIMEOUT", &c.LibreOffice.Timeout)
+
+	l.list("RESOURCE_ALLOW_HOSTS", &c.Resources.AllowHosts)
+	l.int64("RESOURCE_MAX_BYTES", &c.Resources.MaxBytes)
+	l.int("RESOURCE_MAX_COUNT", &c.Resources.MaxCount)
+	l.duration("RESOURCE_TIMEOUT", &c.Resources.Timeout)
+
+	if len(l.errs) > 0 {
+		return errors.New(strings.Join(l.errs, "\n"))
+	}
+	return nil
+}
+
+// get returns the settings of a job class
+func (p *PoolsConfig) get(class string) *PoolConfig {
+	switch class {
+	case ClassPDF:
+		return &p.PDF
+	case ClassOffice:
+		return &p.Office
+	}
+	return &p.Light
+}
+
+// validate reports every invalid setting at once
+func (c *Config) validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Port > 0 && c.Port < 65536, "port: %d is not a valid port", c.Port)
+	if info, err := os.Stat(c.StaticDir); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Sprintf("static_dir: %q is not a directory", c.StaticDir))
+	}
+	if err := os.MkdirAll(c.TempDir, 0700); err != nil {
+		errs = append(errs, fmt.Sprintf("temp_dir: %v", err))
+	}
+	if err := os.MkdirAll(c.WorkDir, 0700); err != nil {
+		errs = append(errs, fmt.Sprintf("work_dir: %v", err))
+	}
+	if c.ScratchDir == "" {
+		errs = append(errs, fmt.Sprintf("scratch_dir: /dev/shm cannot hold %d MB; set scratch_dir, enlarge /dev/shm or set allow_disk_scratch", scratchHeadroom*c.maxUploadBytes()>>20))
+	} else if err := os.MkdirAll(c.ScratchDir, 0700); err != nil {
+		errs = append(errs, fmt.Sprintf("scratch_dir: %v", err))
+	}
+	check(c.MaxUploadMB > 0, "max_upload_mb: must be positive")
+	for format, mb := range c.FormatLimitsMB {
+		_, ok := formatExtensions[format]
+		check(ok, "format_limits_mb: unknown format %q", format)
+		check(mb > 0, "format_limits_mb.%s: must be positive", format)
+	}
+	check(c.MaxArchiveEntryMB > 0, "max_archive_entry_mb: must be positive")
+	check(c.JobRetention > 0, "job_retention: must be positive")
+	for _, class := range []string{ClassLight, ClassPDF, ClassOffice} {
+		if timeout := c.Pools.get(class).Timeout; c.JobRetention < timeout {
+			errs = append(errs, fmt.Sprintf("job_retention: %v is shorter than the %s pool timeout %v", c.JobRetention.Std(), class, timeout.Std()))
+		}
+	}
+	check(c.UploadExpiry > 0, "upload_expiry: must be positive")
+	check(c.CleanupInterval > 0, "cleanup_interval: must be positive")
+	check(c.ResponseGrace >= 0, "response_grace: must not be negative")
+	check(c.InlineDeadline > 0, "inline_deadline: must be positive")
+	check(c.DownloadLinkTTL > 0, "download_link_ttl: must be positive")
+	check(c.Backend == BackendCLI || c.Backend == BackendServer, "backend: %q is not %q or %q", c.Backend, BackendCLI, BackendServer)
+	for _, name := range c.PDFEngines {
+		_, ok := pdfEngineKinds[name]
+		check(ok, "pdf_engines: unknown engine %q", name)
+	}
+	check(c.DiskQuotaMB >= 0, "disk_quota_mb: must not be negative")
+	check(c.MemoryReserveMB >= 0, "memory_reserve_mb: must not be negative")
+
+	for _, class := range []string{ClassLight, ClassPDF, ClassOffice} {
+		p := c.Pools.get(class)
+		check(p.MinWorkers > 0, "pools.%s.min_workers: must be positive", class)
+		check(p.MaxWorkers >= p.MinWorkers, "pools.%s.max_workers: must be at least min_workers", class)
+		check(p.Queue > 0, "pools.%s.queue: must be positive", class)
+		check(p.Timeout > 0, "pools.%s.timeout: must be positive", class)
+		check(p.JobMemoryMB > 0, "pools.%s.job_memory_mb: must be positive", class)
+	}
+
+	check(c.LibreOffice.Instances > 0, "libreoffice.instances: must be positive")
+	check(c.LibreOffice.Timeout > 0, "libreoffice.timeout: must be positive")
+
+	for _, h := range c.Resources.AllowHosts {
+		check(h != "" && !strings.ContainsAny(h, "/: "), "resources.allow_hosts: %q is not a host name", h)
+	}
+	check(c.Resources.MaxBytes > 0, "resources.max_bytes: must be positive")
+	check(c.Resources.MaxCount >= 0, "resources.max_count: must not be negative")
+	check(c.Resources.Timeout > 0, "resources.timeout: must be positive")
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// redacted returns a copy that is safe to show to administrators
+func (c *Config) redacted() *Config {
+	r := *c
+	if r.AdminToken != "" {
+		r.AdminToken = "[redacted]"
+	}
+	if r.DownloadSecret != "" {
+		r.DownloadSecret = "[redacted]"
+	}
+	return &r
+}
+
+// watchReload reloads the configuration on SIGHUP
+func watchReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			reloadConfig()
+		}
+	}()
+}
+
+// reloadConfig applies new non-structural settings. Settings fixed at
+// startup (listener, directories, backends, queue sizes) keep their
+// current values until restart.
+func reloadConfig() {
+	next, err := loadConfig()
+	if err != nil {
+		log.Printf("Config reload failed, keeping current settings:\n%v", err)
+		return
+	}
+	cur := cfg()
+
+	keep := func(name string, changed bool) {
+		if changed {
+			log.Printf("Config reload: %s changed; restart to apply", name)
+		}
+	}
+	keep("port", next.Port != cur.Port)
+	next.Port = cur.Port
+	keep("static_dir", next.StaticDir != cur.StaticDir)
+	next.StaticDir = cur.StaticDir
+	keep("temp_dir", next.TempDir != cur.TempDir)
+	next.TempDir = cur.TempDir
+	keep("work_dir", next.WorkDir != cur.WorkDir)
+	next.WorkDir = cur.WorkDir
+	keep("scratch_dir", next.ScratchDir != cur.ScratchDir)
+	next.ScratchDir = cur.ScratchDir
+	keep("backend", next.Backend != cur.Backend)
+	next.Backend = cur.Backend
+	keep("pdf_engines", strings.Join(next.PDFEngines, ",") != strings.Join(cur.PDFEngines, ","))
+	next.PDFEngines = cur.PDFEngines
+	keep("libreoffice.instances", next.LibreOffice.Instances != cur.LibreOffice.Instances)
+	next.LibreOffice.Instances = cur.LibreOffice.Instances
+	for _, class := range []string{ClassLight, ClassPDF, ClassOffice} {
+		keep("pools."+class+".queue", next.Pools.get(class).Queue != cur.Pools.get(class).Queue)
+		next.Pools.get(class).Queue = cur.Pools.get(class).Queue
+	}
+
+	config.Store(next)
+	log.Printf("Configuration reloaded")
+}
+
+// requireAdmin checks the admin bearer token. Without a configured token
+// only loopback clients are allowed.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := cfg().AdminToken
+	if token == "" {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleAdminConfig shows the effective configuration with secrets redacted
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(cfg().redacted())
+}
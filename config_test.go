@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveScratchDir(t *testing.T) {
+	explicit := t.TempDir()
+	c := &Config{ScratchDir: explicit, MaxUploadMB: 1 << 30}
+	c.resolveScratchDir()
+	if c.ScratchDir != explicit {
+		t.Errorf("explicit scratch_dir replaced with %q", c.ScratchDir)
+	}
+
+	// No tmpfs holds three petabyte-sized uploads
+	c = &Config{MaxUploadMB: 1 << 30, TempDir: os.TempDir()}
+	c.resolveScratchDir()
+	if c.ScratchDir != "" {
+		t.Errorf("scratch_dir = %q, want it unset when /dev/shm is too small", c.ScratchDir)
+	}
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "scratch_dir") {
+		t.Errorf("validate = %v, want a scratch_dir error", err)
+	}
+	c = &Config{MaxUploadMB: 1 << 30, TempDir: os.TempDir(), AllowDiskScratch: true}
+	c.resolveScratchDir()
+	if c.ScratchDir != os.TempDir() {
+		t.Errorf("scratch_dir = %q, want %q with allow_disk_scratch", c.ScratchDir, os.TempDir())
+	}
+
+	c = &Config{MaxUploadMB: 0}
+	c.resolveScratchDir()
+	if _, ok := diskAvailable("/dev/shm"); ok && c.ScratchDir != "/dev/shm" {
+		t.Errorf("scratch_dir = %q, want /dev/shm", c.ScratchDir)
+	}
+}
+
+func TestMaxUploadBytes(t *testing.T) {
+	c := &Config{MaxUploadMB: 32, FormatLimitsMB: map[string]int{"pdf": 64, "txt": 8}}
+	if got := c.maxUploadBytes(); got != 64<<20 {
+		t.Errorf("maxUploadBytes = %d, want %d", got, 64<<20)
+	}
+}
+
+func TestLoadFileExample(t *testing.T) {
+	c := defaultConfig()
+	if err := c.loadFile("convertly.example.yaml"); err != nil {
+		t.Fatalf("loadFile: %v", err)
+	}
+	if c.Port != 8080 || c.FormatLimitsMB["pdf"] != 64 || c.Pools.PDF.Timeout <= 0 {
+		t.Errorf("example config not applied: port %d, pdf limit %d, pdf timeout %v", c.Port, c.FormatLimitsMB["pdf"], c.Pools.PDF.Timeout)
+	}
+}
+
+func TestLoadFileUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("port: 8080\nmax_uplod_mb: 10\n"), 0600)
+	if err := defaultConfig().loadFile(path); err == nil || !strings.Contains(err.Error(), "max_uplod_mb") {
+		t.Errorf("loadFile with a misspelt key: %v", err)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("MAX_UPLOAD_MB", "10")
+	t.Setenv("FORMAT_LIMITS_MB", "pdf=100, docx=20")
+	t.Setenv("POOL_PDF_TIMEOUT", "90s")
+	t.Setenv("PDF_ENGINES", "typst, ,pdflatex")
+	c := defaultConfig()
+	if err := c.loadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxUploadMB != 10 || c.Pools.PDF.Timeout.Std() != 90*time.Second {
+		t.Errorf("max_upload_mb %d, pools.pdf.timeout %v", c.MaxUploadMB, c.Pools.PDF.Timeout)
+	}
+	want := map[string]int{"pdf": 100, "docx": 20, "pptx": 64, "epub": 64}
+	if !reflect.DeepEqual(c.FormatLimitsMB, want) {
+		t.Errorf("format_limits_mb = %v, want %v", c.FormatLimitsMB, want)
+	}
+	if !reflect.DeepEqual(c.PDFEngines, []string{"typst", "pdflatex"}) {
+		t.Errorf("pdf_engines = %v", c.PDFEngines)
+	}
+
+	t.Setenv("PORT", "eighty")
+	t.Setenv("JOB_RETENTION", "forever")
+	err := defaultConfig().loadEnv()
+	if err == nil || !strings.Contains(err.Error(), "PORT") || !strings.Contains(err.Error(), "JOB_RETENTION") {
+		t.Errorf("loadEnv with invalid values: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	valid := func() *Config {
+		c := defaultConfig()
+		c.TempDir, c.WorkDir, c.ScratchDir = dir, filepath.Join(dir, "jobs"), filepath.Join(dir, "scratch")
+		return c
+	}
+	if err := valid().validate(); err != nil {
+		t.Fatalf("defaults rejected: %v", err)
+	}
+
+	c := valid()
+	c.Port = 0
+	c.MaxUploadMB = -1
+	c.FormatLimitsMB["nonsense"] = 1
+	c.Backend = "grpc"
+	c.Pools.Light.MaxWorkers = 0
+	c.Resources.AllowHosts = []string{"http://example.com"}
+	c.JobRetention = Duration(time.Minute)
+	err := c.validate()
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, key := range []string{"port", "max_upload_mb", "format_limits_mb", "backend", "pools.light.max_workers", "resources.allow_hosts", "job_retention"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error does not mention %s: %v", key, err)
+		}
+	}
+}
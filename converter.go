@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conversion backends
+const (
+	BackendCLI         = "cli"
+	BackendServer      = "server"
+	BackendLibreOffice = "libreoffice"
+)
+
+// errBackendUnavailable means a converter could not run the job at all and
+// the next candidate backend should be tried
+var errBackendUnavailable = errors.New("backend unavailable")
+
+// Converter converts a document between formats
+type Converter interface {
+	// Name identifies the backend in requests, timings and metrics
+	Name() string
+	// CanConvert reports whether the backend handles a format pair
+	CanConvert(from, to string) bool
+	// Convert writes req.OutputPath. The result is non-nil even on error
+	// so that partial information such as PDF attempts can be reported.
+	Convert(ctx context.Context, req ConvertRequest) (*ConvertResult, error)
+}
+
+// ConvertRequest describes a single conversion for a backend
+type ConvertRequest struct {
+	JobID      string
+	InputPath  string
+	FromFmt    string
+	ToFmt      string
+	OutputPath string
+	PDFEngine  string
+	WorkDir    string // current directory and HOME for converter processes
+}
+
+// ConvertResult carries backend-specific details of a conversion
+type ConvertResult struct {
+	Warnings    []Warning
+	PDFEngine   string
+	PDFAttempts []PDFAttempt
+}
+
+// converters holds registered backends in default preference order
+var converters []Converter
+
+// registerConverter adds a backend to the registry
+func registerConverter(c Converter) {
+	converters = append(converters, c)
+}
+
+// registerConverters installs the built-in backends
+func registerConverters() {
+	cli := pandocCLIConverter{}
+	registerConverter(tablesConverter{ast: cli})
+	if pandocSrv != nil {
+		registerConverter(pandocServerConverter{srv: pandocSrv})
+	}
+	registerConverter(cli)
+	if lo := newLibreOfficeConverter(); lo != nil {
+		registerConverter(lo)
+	}
+}
+
+// converterByName looks up a registered backend
+func converterByName(name string) Converter {
+	for _, c := range converters {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// formatPreferrer is implemented by backends that should handle some
+// format pairs even when no backend was requested
+type formatPreferrer interface {
+	Prefers(from, to string) bool
+}
+
+// preferredBackend picks the backend to try first: the requested one, a
+// backend that claims the format pair, or the default
+func preferredBackend(requested, from, to string) string {
+	if requested != "" {
+		return requested
+	}
+	for _, c := range converters {
+		if p, ok := c.(formatPreferrer); ok && p.Prefers(from, to) && c.CanConvert(from, to) {
+			return c.Name()
+		}
+	}
+	return cfg().Backend
+}
+
+// routeConverters returns the backends able to convert from→to, with the
+// preferred backend first and the rest in registry order
+func routeConverters(from, to, preferred string) []Converter {
+	var out []Converter
+	if c := converterByName(preferred); c != nil && c.CanConvert(from, to) {
+		out = append(out, c)
+	}
+	for _, c := range converters {
+		if c.Name() != preferred && c.CanConvert(from, to) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// pandocCLIConverter runs a pandoc process per conversion
+type pandocCLIConverter struct{}
+
+func (pandocCLIConverter) Name() string { return BackendCLI }
+
+func (pandocCLIConverter) CanConvert(from, to string) bool {
+	_, native := nativeWriters[to]
+	return !native
+}
+
+// Convert runs pandoc, retrying PDF builds with other installed engines
+// when the first engine fails with an engine-specific error
+func (pandocCLIConverter) Convert(ctx context.Context, req ConvertRequest) (*ConvertResult, error) {
+	res := &ConvertResult{}
+
+	// Build pandoc command with improved flags
+	args := []string{
+		req.InputPath,
+		"-f", req.FromFmt,
+		"-t", req.ToFmt,
+		"--standalone", // Create complete documents (fixes DOCX issues)
+		"--wrap=none",  // Prevent unwanted line wrapping
+	}
+	if pandocSandboxSupported {
+		// Readers and writers may only read the input file
+		args = append(args, "--sandbox")
+	}
+
+	// PDF builds start with the requested or most preferred engine and fall
+	// back to the other installed engines on engine-specific failures
+	engines := []string{""}
+	if req.ToFmt == "pdf" {
+		selectedEngine := req.PDFEngine
+		if selectedEngine == "" && len(availablePDFEngines) > 0 {
+			selectedEngine = availablePDFEngines[0]
+		}
+
+		if selectedEngine == "" {
+			// No PDF engine available - fail fast with clear error
+			return res, newConversionError(ErrCodeEngineMissing, "PDF conversion requires a PDF engine (xelatex, pdflatex, lualatex, weasyprint, wkhtmltopdf, typst or context) to be installed")
+		}
+
+		engines = pdfEngineChain(selectedEngine)
+	}
+
+	// Pandoc writes machine-readable warnings to a JSON log
+	logPath := req.OutputPath + ".log"
+	defer os.Remove(logPath)
+
+	for i, engine := range engines {
+		runArgs := args
+		if engine != "" {
+			runArgs = append(append([]string{}, args...), pdfEngineArgs(engine)...)
+		}
+
+		// Output file must be last
+		runArgs = append(runArgs, "--log="+logPath, "-o", req.OutputPath)
+
+		cmd := sandboxCommand(ctx, req.WorkDir, "pandoc", runArgs...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		start := time.Now()
+		err := cmd.Run()
+
+		if engine != "" {
+			attempt := PDFAttempt{Engine: engine, DurationMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				attempt.Error = strings.TrimSpace(stderr.String())
+				if attempt.Error == "" {
+					attempt.Error = err.Error()
+				}
+			} else {
+				res.PDFEngine = engine
+			}
+			res.PDFAttempts = append(res.PDFAttempts, attempt)
+		}
+
+		if err == nil {
+			res.Warnings = readPandocLog(logPath, stderr.String())
+			return res, nil
+		}
+
+		exitCode := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		if engine != "" && i < len(engines)-1 && ctx.Err() == nil && isRetryablePDFFailure(exitCode, stderr.String()) {
+			log.Printf("Job %s: PDF engine %s failed, retrying with %s", req.JobID, engine, engines[i+1])
+			continue
+		}
+
+		return res, classifyPandocError(ctx, err, stderr.String())
+	}
+	return res, nil
+}
+
+// pandocServerConverter sends text conversions to the long-lived pandoc server
+type pandocServerConverter struct {
+	srv *pandocServer
+}
+
+func (pandocServerConverter) Name() string { return BackendServer }
+
+func (c pandocServerConverter) CanConvert(from, to string) bool {
+	return c.srv.available() && serverCanConvert(from, to)
+}
+
+func (c pandocServerConverter) Convert(ctx context.Context, req ConvertRequest) (*ConvertResult, error) {
+	res := &ConvertResult{}
+	text, err := os.ReadFile(req.InputPath)
+	if err != nil {
+		return res, fmt.Errorf("failed to read input: %w", err)
+	}
+	out, warnings, err := c.srv.convert(ctx, string(text), req.FromFmt, req.ToFmt)
+	if errors.Is(err, errBackendUnavailable) {
+		return res, err
+	}
+	if err != nil {
+		return res, classifyPandocError(ctx, err, err.Error())
+	}
+	if err := os.WriteFile(req.OutputPath, out, 0600); err != nil {
+		return res, fmt.Errorf("failed to write output: %w", err)
+	}
+	res.Warnings = warnings
+	return res, nil
+}
+
+// tablesConverter extracts tables from the pandoc JSON AST of the input
+type tablesConverter struct {
+	ast Converter
+}
+
+func (tablesConverter) Name() string { return "tables" }
+
+func (tablesConverter) CanConvert(from, to string) bool {
+	_, ok := nativeWriters[to]
+	return ok
+}
+
+func (c tablesConverter) Convert(ctx context.Context, req ConvertRequest) (*ConvertResult, error) {
+	writer := nativeWriters[req.ToFmt]
+
+	astReq := req
+	astReq.ToFmt = "json"
+	astReq.OutputPath = req.OutputPath + ".json"
+	defer os.Remove(astReq.OutputPath)
+
+	res, err := c.ast.Convert(ctx, astReq)
+	if err != nil {
+		return res, err
+	}
+	if err := writer.Write(astReq.OutputPath, req.OutputPath); err != nil {
+		os.Remove(req.OutputPath)
+		return res, newConversionError(ErrCodeConversionFailed, fmt.Sprintf("table extraction failed: %v", err))
+	}
+	return res, nil
+}
+
+// JobTimings records where a job spent its time, per backend
+type JobTimings struct {
+	Backend   string `json:"backend"`
+	Class     string `json:"class"`
+	QueueMs   int64  `json:"queue_ms"`
+	ConvertMs int64  `json:"convert_ms"`
+	TotalMs   int64  `json:"total_ms"`
+}
+
+// backendStats aggregates conversion durations per backend for benchmarking
+var backendStats = struct {
+	sync.Mutex
+	count   map[string]int64
+	totalMs map[string]int64
+}{count: make(map[string]int64), totalMs: make(map[string]int64)}
+
+// recordBackendTiming adds a successful conversion to the backend stats
+func recordBackendTiming(backend string, d time.Duration) {
+	backendStats.Lock()
+	backendStats.count[backend]++
+	backendStats.totalMs[backend] += d.Milliseconds()
+	backendStats.Unlock()
+}
+
+// backendStatsSnapshot returns count and average duration per backend
+func backendStatsSnapshot() map[string]interface{} {
+	backendStats.Lock()
+	defer backendStats.Unlock()
+	out := make(map[string]interface{})
+	for b, n := range backendStats.count {
+		out[b] = map[string]interface{}{
+			"conversions":    n,
+			"avg_convert_ms": backendStats.totalMs[b] / n,
+		}
+	}
+	return out
+}
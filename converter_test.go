@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConvertDisabledBackend(t *testing.T) {
+	withConfig(t, func(c *Config) { c.WorkDir = t.TempDir() })
+	saved := converters
+	converters = []Converter{pandocCLIConverter{}}
+	t.Cleanup(func() { converters = saved })
+
+	tests := []struct {
+		backend, want string
+	}{
+		{BackendServer, "Backend not enabled on this server: server"},
+		{BackendLibreOffice, "Backend not enabled on this server: libreoffice"},
+		{"grpc", "Unknown backend: grpc"},
+	}
+	for _, tt := range tests {
+		body := `{"content": "# Hi", "from": "markdown", "to": "html", "backend": "` + tt.backend + `"}`
+		r := httptest.NewRequest("POST", "/api/convert", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handleConvert(w, r)
+		if w.Code != http.StatusBadRequest || strings.TrimSpace(w.Body.String()) != tt.want {
+			t.Errorf("backend %s: status %d, body %q; want 400, %q", tt.backend, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+func TestRouteConverters(t *testing.T) {
+	saved := converters
+	cli := pandocCLIConverter{}
+	converters = []Converter{tablesConverter{ast: cli}, cli}
+	t.Cleanup(func() { converters = saved })
+
+	names := func(cs []Converter) []string {
+		var out []string
+		for _, c := range cs {
+			out = append(out, c.Name())
+		}
+		return out
+	}
+	if got := names(routeConverters("markdown", "html", BackendCLI)); strings.Join(got, ",") != BackendCLI {
+		t.Errorf("markdown to html routed to %v", got)
+	}
+	// A requested backend that is not registered falls back to the others
+	if got := names(routeConverters("markdown", "html", BackendServer)); strings.Join(got, ",") != BackendCLI {
+		t.Errorf("unregistered preference routed to %v", got)
+	}
+}
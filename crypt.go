@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Stored inputs and outputs are encrypted with AES-256-GCM under a per-job
+// key that only exists in memory, in the job record. Deleting a job zeroes
+// its key, so files that outlive the record (a failed removal, a disk
+// snapshot) cannot be read. Converters see plaintext only in a scratch
+// directory that is removed as soon as they finish.
+//
+// A stored file is a random salt followed by the plaintext in chunks,
+// each sealed on its own under a key derived from the job key and the
+// salt. A chunk's nonce is its index, with a flag in the last byte marking
+// the final chunk so truncation is detected. Chunking keeps the files
+// seekable for Range requests.
+
+const (
+	cryptSaltSize  = 16
+	cryptChunkSize = 64 << 10
+	cryptTagSize   = 16
+	cryptStride    = cryptChunkSize + cryptTagSize
+)
+
+var errUnreadable = errors.New("stored file is corrupt or its key was deleted")
+
+// newJobKey returns a random key for a job's stored files
+func newJobKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// fileAEAD derives the cipher of one stored file from the job key
+func fileAEAD(key, salt []byte) (cipher.AEAD, error) {
+	if len(key) == 0 || bytes.Count(key, []byte{0}) == len(key) {
+		return nil, errUnreadable
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(index))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts a stored file as it is written. A full chunk is
+// held back until more data arrives, so Close can seal it as final.
+type encryptWriter struct {
+	f     *os.File
+	aead  cipher.AEAD
+	buf   []byte
+	index int64
+}
+
+// createEncrypted creates a stored file encrypted under key
+func createEncrypted(path string, key []byte) (*encryptWriter, error) {
+	salt := make([]byte, cryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := fileAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(salt); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &encryptWriter{f: f, aead: aead, buf: make([]byte, 0, cryptChunkSize)}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == cryptChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):cryptChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) seal(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.index, final), w.buf, nil)
+	w.index++
+	w.buf = w.buf[:0]
+	_, err := w.f.Write(sealed)
+	return err
+}
+
+// Close seals the final chunk and closes the file
+func (w *encryptWriter) Close() error {
+	err := w.seal(true)
+	clear(w.buf[:cap(w.buf)])
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// decryptReader reads the plaintext of a stored file, one chunk at a time
+type decryptReader struct {
+	f      *os.File
+	aead   cipher.AEAD
+	body   int64 // ciphertext size after the salt
+	size   int64 // plaintext size
+	chunks int64
+	pos    int64
+	index  int64 // chunk held in plain, or -1
+	plain  []byte
+	sealed []byte
+}
+
+// openEncrypted opens a stored file encrypted under key
+func openEncrypted(path string, key []byte) (*decryptReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	salt := make([]byte, cryptSaltSize)
+	if _, err := io.ReadFull(f, salt); err != nil {
+		f.Close()
+		return nil, errUnreadable
+	}
+	aead, err := fileAEAD(key, salt)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	body := info.Size() - cryptSaltSize
+	chunks := (body + cryptStride - 1) / cryptStride
+	size := body - chunks*cryptTagSize
+	if chunks == 0 || size < 0 || body-(chunks-1)*cryptStride < cryptTagSize {
+		f.Close()
+		return nil, errUnreadable
+	}
+	return &decryptReader{
+		f: f, aead: aead, body: body, size: size, chunks: chunks, index: -1,
+		sealed: make([]byte, cryptStride),
+	}, nil
+}
+
+// Size is the plaintext size
+func (d *decryptReader) Size() int64 {
+	return d.size
+}
+
+// load decrypts chunk i
+func (d *decryptReader) load(i int64) error {
+	if i == d.index {
+		return nil
+	}
+	n := min(int64(cryptStride), d.body-i*cryptStride)
+	sealed := d.sealed[:n]
+	if _, err := d.f.ReadAt(sealed, cryptSaltSize+i*cryptStride); err != nil {
+		return err
+	}
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(i, i == d.chunks-1), sealed, nil)
+	if err != nil {
+		d.index = -1
+		return errUnreadable
+	}
+	d.plain, d.index = plain, i
+	return nil
+}
+
+func (d *decryptReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if off >= d.size {
+			return n, io.EOF
+		}
+		i := off / cryptChunkSize
+		if err := d.load(i); err != nil {
+			return n, err
+		}
+		k := copy(p[n:], d.plain[off-i*cryptChunkSize:])
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	n, err := d.ReadAt(p, d.pos)
+	d.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decryptReader) Close() error {
+	clear(d.plain)
+	return d.f.Close()
+}
+
+// encryptFile stores a plaintext file encrypted under key
+func encryptFile(src, dst string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return encryptFrom(in, dst, key)
+}
+
+// encryptFrom stores everything read from r encrypted under key
+func encryptFrom(r io.Reader, dst string, key []byte) error {
+	w, err := createEncrypted(dst, key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// decryptFile writes the plaintext of a stored file to dst
+func decryptFile(src, dst string, key []byte) error {
+	in, err := openEncrypted(src, key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// detectStoredFormat runs format detection on an encrypted input
+func detectStoredFormat(path string, key []byte, ext string, preferContent bool) Detection {
+	d, err := openEncrypted(path, key)
+	if err != nil {
+		return detectInputFormat(bytes.NewReader(nil), 0, ext, preferContent)
+	}
+	defer d.Close()
+	return detectInputFormat(d, d.Size(), ext, preferContent)
+}
+
+// uploadStream returns the AES-CTR keystream of a resumable upload
+// positioned at offset. Uploads are appended in arbitrary pieces, which
+// a seekable stream cipher handles; they are re-encrypted with the job
+// key once claimed.
+func uploadStream(key, iv []byte, offset int64) (cipher.Stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, iv)
+	add, carry := uint64(offset/aes.BlockSize), uint64(0)
+	for i := aes.BlockSize - 1; i >= 0; i-- {
+		sum := uint64(counter[i]) + add&0xff + carry
+		counter[i] = byte(sum)
+		carry = sum >> 8
+		add >>= 8
+	}
+	stream := cipher.NewCTR(block, counter)
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	return stream, nil
+}
+
+// shredKey zeroes a key so nothing encrypted under it can be read again
+func shredKey(key []byte) {
+	clear(key)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// storeEncrypted encrypts plain to a new file and returns its path
+func storeEncrypted(t *testing.T, plain, key []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stored")
+	if err := encryptFrom(bytes.NewReader(plain), path, key); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readAll decrypts a stored file
+func readAll(path string, key []byte) ([]byte, error) {
+	d, err := openEncrypted(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return io.ReadAll(d)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, cryptChunkSize - 1, cryptChunkSize, cryptChunkSize + 1, 3*cryptChunkSize + 17} {
+		key, plain := newJobKey(), randomBytes(size)
+		path := storeEncrypted(t, plain, key)
+
+		stored, _ := os.ReadFile(path)
+		chunks := max(1, (size+cryptChunkSize-1)/cryptChunkSize)
+		if want := cryptSaltSize + size + chunks*cryptTagSize; len(stored) != want {
+			t.Errorf("size %d: stored %d bytes, want %d", size, len(stored), want)
+		}
+		if size >= 16 && bytes.Contains(stored, plain[:16]) {
+			t.Errorf("size %d: plaintext stored as is", size)
+		}
+		got, err := readAll(path, key)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip returned %d bytes, %v", size, len(got), err)
+		}
+	}
+}
+
+func TestEncryptedReadAtSeek(t *testing.T) {
+	key, plain := newJobKey(), randomBytes(2*cryptChunkSize+100)
+	d, err := openEncrypted(storeEncrypted(t, plain, key), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.Size() != int64(len(plain)) {
+		t.Fatalf("Size = %d, want %d", d.Size(), len(plain))
+	}
+
+	// Across a chunk boundary, then back into an earlier chunk
+	for _, off := range []int64{cryptChunkSize - 10, 5, 2 * cryptChunkSize} {
+		p := make([]byte, 20)
+		if n, err := d.ReadAt(p, off); n != 20 || err != nil || !bytes.Equal(p, plain[off:off+20]) {
+			t.Errorf("ReadAt(%d) = %d, %v", off, n, err)
+		}
+	}
+	p := make([]byte, 200)
+	if n, err := d.ReadAt(p, int64(len(plain))-50); n != 50 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v; want 50, EOF", n, err)
+	}
+
+	if pos, err := d.Seek(-30, io.SeekEnd); err != nil || pos != int64(len(plain))-30 {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	rest, err := io.ReadAll(d)
+	if err != nil || !bytes.Equal(rest, plain[len(plain)-30:]) {
+		t.Errorf("read after Seek = %d bytes, %v", len(rest), err)
+	}
+	if _, err := d.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative position accepted")
+	}
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	key, plain := newJobKey(), randomBytes(2*cryptChunkSize)
+	path := storeEncrypted(t, plain, key)
+	stored, _ := os.ReadFile(path)
+
+	flipped := bytes.Clone(stored)
+	flipped[100] ^= 1
+	swapped := slices.Concat(stored[:cryptSaltSize], stored[cryptSaltSize+cryptStride:], stored[cryptSaltSize:cryptSaltSize+cryptStride])
+
+	tests := map[string][]byte{
+		"flipped byte":        flipped,
+		"swapped chunks":      swapped,
+		"dropped final chunk": stored[:cryptSaltSize+cryptStride],
+		"cut mid-chunk":       stored[:len(stored)-10],
+		"salt only":           stored[:cryptSaltSize],
+	}
+	for name, data := range tests {
+		p := filepath.Join(t.TempDir(), "tampered")
+		os.WriteFile(p, data, 0600)
+		if _, err := readAll(p, key); !errors.Is(err, errUnreadable) {
+			t.Errorf("%s: read returned %v, want errUnreadable", name, err)
+		}
+	}
+}
+
+func TestShreddedKey(t *testing.T) {
+	key := newJobKey()
+	path := storeEncrypted(t, []byte("secret"), key)
+	if _, err := readAll(path, newJobKey()); !errors.Is(err, errUnreadable) {
+		t.Errorf("read with another key: %v", err)
+	}
+	shredKey(key)
+	if _, err := readAll(path, key); !errors.Is(err, errUnreadable) {
+		t.Errorf("read after shredding the key: %v", err)
+	}
+	if err := encryptFrom(bytes.NewReader(nil), filepath.Join(t.TempDir(), "new"), key); err == nil {
+		t.Error("encrypted under a shredded key")
+	}
+}
+
+func TestUploadStreamOffsets(t *testing.T) {
+	key, plain := newJobKey(), randomBytes(1000)
+	// An IV at the top of the counter range carries into higher bytes
+	iv := bytes.Repeat([]byte{0xff}, 16)
+
+	whole := make([]byte, len(plain))
+	s, err := uploadStream(key, iv, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.XORKeyStream(whole, plain)
+
+	pieces := make([]byte, len(plain))
+	for _, cut := range [][2]int{{0, 7}, {7, 16}, {16, 333}, {333, 1000}} {
+		s, err := uploadStream(key, iv, int64(cut[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.XORKeyStream(pieces[cut[0]:cut[1]], plain[cut[0]:cut[1]])
+	}
+	if !bytes.Equal(whole, pieces) {
+		t.Error("stream resumed at an offset differs from the continuous stream")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Canonical names of the character encodings we can transcode from
+const (
+	encUTF8        = "utf-8"
+	encUTF16LE     = "utf-16le"
+	encUTF16BE     = "utf-16be"
+	encUTF32LE     = "utf-32le"
+	encUTF32BE     = "utf-32be"
+	encWindows1252 = "windows-1252"
+	encISO88591    = "iso-8859-1"
+	encISO885915   = "iso-8859-15"
+)
+
+// charsetAliases maps client-supplied charset labels to canonical names
+var charsetAliases = map[string]string{
+	"utf-8": encUTF8, "utf8": encUTF8,
+	"utf-16le": encUTF16LE, "utf-16be": encUTF16BE, "utf-16": encUTF16LE,
+	"utf-32le": encUTF32LE, "utf-32be": encUTF32BE, "utf-32": encUTF32LE,
+	"windows-1252": encWindows1252, "cp1252": encWindows1252,
+	"iso-8859-1": encISO88591, "latin1": encISO88591, "latin-1": encISO88591,
+	"iso-8859-15": encISO885915, "latin9": encISO885915, "latin-9": encISO885915,
+}
+
+// binaryFormats are container formats that must never be transcoded
+var binaryFormats = map[string]bool{
+	"docx": true, "odt": true, "epub": true, "pptx": true,
+	"pdf": true, "xlsx": true, "ods": true,
+}
+
+// byteOrderMarks in the order they must be tested (UTF-32LE before UTF-16LE)
+var byteOrderMarks = []struct {
+	bom []byte
+	enc string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, encUTF8},
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, encUTF32LE},
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, encUTF32BE},
+	{[]byte{0xFF, 0xFE}, encUTF16LE},
+	{[]byte{0xFE, 0xFF}, encUTF16BE},
+}
+
+// windows1252High maps bytes 0x80-0x9F; the rest of the code page matches Latin-1
+var windows1252High = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// iso885915Diff lists where Latin-9 differs from Latin-1
+var iso885915Diff = map[byte]rune{
+	0xA4: 0x20AC, 0xA6: 0x0160, 0xA8: 0x0161, 0xB4: 0x017D,
+	0xB8: 0x017E, 0xBC: 0x0152, 0xBD: 0x0153, 0xBE: 0x0178,
+}
+
+// normalizeCharset resolves a charset label to its canonical name
+func normalizeCharset(label string) (string, bool) {
+	enc, ok := charsetAliases[strings.ToLower(strings.TrimSpace(label))]
+	return enc, ok
+}
+
+// detectEncoding guesses the encoding of text data from BOMs, NUL byte
+// patterns and UTF-8 validity, falling back to Windows-1252
+func detectEncoding(data []byte) string {
+	for _, b := range byteOrderMarks {
+		if bytes.HasPrefix(data, b.bom) {
+			return b.enc
+		}
+	}
+
+	sample := data[:min(len(data), 4096)]
+	if len(sample) >= 4 {
+		var evenZeros, oddZeros int
+		for i, c := range sample {
+			if c == 0 {
+				if i%2 == 0 {
+					evenZeros++
+				} else {
+					oddZeros++
+				}
+			}
+		}
+		half := len(sample) / 2
+		switch {
+		case oddZeros > half*3/10 && evenZeros <= half/20:
+			return encUTF16LE
+		case evenZeros > half*3/10 && oddZeros <= half/20:
+			return encUTF16BE
+		}
+	}
+
+	if utf8.Valid(data) {
+		return encUTF8
+	}
+	return encWindows1252
+}
+
+// decodeToUTF8 converts data in the given encoding to UTF-8, dropping any BOM
+func decodeToUTF8(data []byte, enc string) ([]byte, error) {
+	for _, b := range byteOrderMarks {
+		if b.enc == enc && bytes.HasPrefix(data, b.bom) {
+			data = data[len(b.bom):]
+			break
+		}
+	}
+
+	switch enc {
+	case encUTF8:
+		if !utf8.Valid(data) {
+			return nil, fmt.Errorf("input is not valid UTF-8")
+		}
+		return data, nil
+	case encUTF16LE, encUTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		if enc == encUTF16BE {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[2*i:])
+		}
+		return []byte(string(utf16.Decode(units))), nil
+	case encUTF32LE, encUTF32BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		if enc == encUTF32BE {
+			order = binary.BigEndian
+		}
+		out := make([]byte, 0, len(data))
+		for i := 0; i+4 <= len(data); i += 4 {
+			out = utf8.AppendRune(out, rune(order.Uint32(data[i:])))
+		}
+		return out, nil
+	case encWindows1252, encISO88591, encISO885915:
+		out := make([]byte, 0, len(data)+len(data)/4)
+		for _, c := range data {
+			r := rune(c)
+			if enc == encWindows1252 && c >= 0x80 && c <= 0x9F {
+				r = windows1252High[c-0x80]
+			} else if enc == encISO885915 {
+				if d, ok := iso885915Diff[c]; ok {
+					r = d
+				}
+			}
+			out = utf8.AppendRune(out, r)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported charset: %s", enc)
+}
+
+// transcodeFile rewrites a text file as UTF-8 in place. When charset is
+// empty the encoding is detected. The encoding used is returned.
+func transcodeFile(path, charset string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	enc := charset
+	if enc == "" {
+		enc = detectEncoding(data)
+	}
+	if enc == encUTF8 && !bytes.HasPrefix(data, byteOrderMarks[0].bom) && utf8.Valid(data) {
+		return enc, nil
+	}
+
+	out, err := decodeToUTF8(data, enc)
+	if err != nil {
+		return enc, err
+	}
+	return enc, os.WriteFile(path, out, 0600)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeCharset(t *testing.T) {
+	tests := map[string]string{
+		" UTF8 ":  encUTF8,
+		"Latin1":  encISO88591,
+		"cp1252":  encWindows1252,
+		"UTF-16":  encUTF16LE,
+		"latin-9": encISO885915,
+	}
+	for label, want := range tests {
+		if got, ok := normalizeCharset(label); !ok || got != want {
+			t.Errorf("normalizeCharset(%q) = %q, %v; want %q", label, got, ok, want)
+		}
+	}
+	if _, ok := normalizeCharset("shift_jis"); ok {
+		t.Error("unsupported charset accepted")
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"utf-8 bom", "\xef\xbb\xbfhi", encUTF8},
+		{"utf-32le bom", "\xff\xfe\x00\x00h\x00\x00\x00", encUTF32LE},
+		{"utf-32be bom", "\x00\x00\xfe\xff\x00\x00\x00h", encUTF32BE},
+		{"utf-16le bom", "\xff\xfeh\x00", encUTF16LE},
+		{"utf-16be bom", "\xfe\xff\x00h", encUTF16BE},
+		{"utf-16le without bom", "h\x00e\x00l\x00l\x00o\x00", encUTF16LE},
+		{"utf-16be without bom", "\x00h\x00e\x00l\x00l\x00o", encUTF16BE},
+		{"utf-8", "caf\xc3\xa9", encUTF8},
+		{"ascii", "plain", encUTF8},
+		{"legacy", "caf\xe9 \x93quoted\x94", encWindows1252},
+	}
+	for _, tt := range tests {
+		if got := detectEncoding([]byte(tt.data)); got != tt.want {
+			t.Errorf("%s: detectEncoding = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeToUTF8(t *testing.T) {
+	tests := []struct {
+		data, enc, want string
+	}{
+		{"\xef\xbb\xbfcaf\xc3\xa9", encUTF8, "café"},
+		{"\xff\xfec\x00\xe9\x00=\xd8\x00\xde", encUTF16LE, "cé😀"},
+		{"\x00c\x00\xe9", encUTF16BE, "cé"},
+		{"\xff\xfe\x00\x00\xac\x20\x00\x00", encUTF32LE, "€"},
+		{"\x00\x00\x20\xac", encUTF32BE, "€"},
+		{"\x80 \x93q\x94 \xe9", encWindows1252, "€ “q” é"},
+		{"\x80 \xa4 \xe9", encISO88591, "\u0080 ¤ é"},
+		{"\xa4 \xbd \xe9", encISO885915, "€ œ é"},
+	}
+	for _, tt := range tests {
+		got, err := decodeToUTF8([]byte(tt.data), tt.enc)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("decodeToUTF8(%q, %s) = %q, %v; want %q", tt.data, tt.enc, got, err, tt.want)
+		}
+	}
+	if _, err := decodeToUTF8([]byte("caf\xe9"), encUTF8); err == nil {
+		t.Error("invalid UTF-8 accepted")
+	}
+	if _, err := decodeToUTF8([]byte("x"), "ebcdic"); err == nil {
+		t.Error("unsupported encoding accepted")
+	}
+}
+
+func TestTranscodeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.md")
+	os.WriteFile(path, []byte("# Caf\xe9"), 0600)
+	enc, err := transcodeFile(path, "")
+	got, _ := os.ReadFile(path)
+	if err != nil || enc != encWindows1252 || string(got) != "# Café" {
+		t.Errorf("transcodeFile = %q, %v; file %q", enc, err, got)
+	}
+
+	// An explicit charset overrides detection
+	os.WriteFile(path, []byte("\xa4"), 0600)
+	if enc, err := transcodeFile(path, encISO885915); err != nil || enc != encISO885915 {
+		t.Errorf("transcodeFile with charset = %q, %v", enc, err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "€" {
+		t.Errorf("file = %q, want €", got)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Stable error codes returned to API clients
+const (
+	ErrCodeUnknownFormat       = "unknown_format"
+	ErrCodeParseError          = "parse_error"
+	ErrCodeLatexMissingPackage = "latex_missing_package"
+	ErrCodeLatexUnicode        = "latex_unicode"
+	ErrCodeResourceNotFound    = "resource_not_found"
+	ErrCodeTimeout             = "timeout"
+	ErrCodeEngineMissing       = "engine_missing"
+	ErrCodeConversionFailed    = "conversion_failed"
+	ErrCodeTooLarge            = "too_large"
+)
+
+// errorHints are shown to users alongside each error code
+var errorHints = map[string]string{
+	ErrCodeUnknownFormat:       "Check that the source and target formats are among those listed by /api/formats.",
+	ErrCodeParseError:          "The input could not be parsed. Check that the source format matches the file's actual content.",
+	ErrCodeLatexMissingPackage: "The document needs a LaTeX package that isn't installed. Try a different PDF engine.",
+	ErrCodeLatexUnicode:        "The document contains characters the LaTeX engine can't typeset. Try the xelatex engine or an HTML-based engine.",
+	ErrCodeResourceNotFound:    "An image or other referenced file could not be found. Embed resources or remove the reference.",
+	ErrCodeTimeout:             "The conversion took too long. Try a smaller document or a simpler target format.",
+	ErrCodeEngineMissing:       "The tool needed for this conversion isn't installed on the server.",
+	ErrCodeConversionFailed:    "The conversion failed. See the detail field for the converter's output.",
+	ErrCodeTooLarge:            "The input is larger than the server accepts for this format. Split the document or reduce embedded images.",
+}
+
+// ConversionError is a classified conversion failure
+type ConversionError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Detail  string `json:"detail,omitempty"` // raw converter output
+}
+
+func (e *ConversionError) Error() string {
+	return e.Message
+}
+
+// newConversionError builds an error with the standard hint for its code
+func newConversionError(code, message string) *ConversionError {
+	return &ConversionError{Code: code, Message: message, Hint: errorHints[code]}
+}
+
+var (
+	lineColumnRe     = regexp.MustCompile(`line (\d+),? column (\d+)`)
+	missingPackageRe = regexp.MustCompile("File `([^']+)\\.sty' not found")
+	unknownFormatRe  = regexp.MustCompile(`Unknown (input|output|reader|writer)( format)?:? ?(\S*)`)
+)
+
+// classifyPandocError turns a failed pandoc run into a ConversionError
+func classifyPandocError(ctx context.Context, err error, stderr string) *ConversionError {
+	stderr = strings.TrimSpace(stderr)
+
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+
+	var ce *ConversionError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		ce = newConversionError(ErrCodeTimeout, "Conversion timed out")
+
+	case errors.Is(err, exec.ErrNotFound):
+		ce = newConversionError(ErrCodeEngineMissing, "Pandoc is not installed on the server")
+
+	case unknownFormatRe.MatchString(stderr):
+		m := unknownFormatRe.FindStringSubmatch(stderr)
+		ce = newConversionError(ErrCodeUnknownFormat, "Unknown format "+strings.Trim(m[3], `"`))
+
+	case exitCode == pandocExitPDFProgramMissing || strings.Contains(stderr, "not found. Please select a different --pdf-engine"):
+		ce = newConversionError(ErrCodeEngineMissing, "The selected PDF engine is not installed")
+
+	case missingPackageRe.MatchString(stderr):
+		pkg := missingPackageRe.FindStringSubmatch(stderr)[1]
+		ce = newConversionError(ErrCodeLatexMissingPackage, fmt.Sprintf("LaTeX package %q is not installed", pkg))
+
+	case strings.Contains(stderr, "not set up for use with LaTeX") ||
+		strings.Contains(stderr, "Unicode character") ||
+		strings.Contains(stderr, "Missing character: There is no"):
+		ce = newConversionError(ErrCodeLatexUnicode, "The PDF engine cannot render some characters in the document")
+
+	case strings.Contains(stderr, "Could not fetch resource") ||
+		strings.Contains(stderr, "could not find image") ||
+		strings.Contains(stderr, "File not found in resource path") ||
+		strings.Contains(stderr, "does not exist (No such file or directory)"):
+		ce = newConversionError(ErrCodeResourceNotFound, "A referenced resource could not be found")
+
+	case lineColumnRe.MatchString(stderr) || strings.Contains(stderr, "parse error") ||
+		strings.Contains(stderr, "Error parsing") || strings.Contains(stderr, "Error at"):
+		ce = newConversionError(ErrCodeParseError, "The input document could not be parsed")
+
+	default:
+		ce = newConversionError(ErrCodeConversionFailed, fmt.Sprintf("Conversion failed: %v", err))
+	}
+
+	// LaTeX line numbers refer to the generated .tex, not the user's source
+	if ce.Code != ErrCodeLatexMissingPackage && ce.Code != ErrCodeLatexUnicode {
+		if m := lineColumnRe.FindStringSubmatch(stderr); m != nil {
+			ce.Line, _ = strconv.Atoi(m[1])
+			ce.Column, _ = strconv.Atoi(m[2])
+		}
+	}
+	ce.Detail = stderr
+	return ce
+}
+
+// asConversionError classifies any job error for API responses
+func asConversionError(err error) *ConversionError {
+	var ce *ConversionError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	return newConversionError(ErrCodeConversionFailed, err.Error())
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestClassifyPandocError(t *testing.T) {
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		name, stderr string
+		err          error
+		code         string
+		line, column int
+	}{
+		{"not installed", "", exec.ErrNotFound, ErrCodeEngineMissing, 0, 0},
+		{"unknown writer", `Unknown output format "docz"`, failed, ErrCodeUnknownFormat, 0, 0},
+		{"pdf engine", "xelatex not found. Please select a different --pdf-engine or install xelatex", failed, ErrCodeEngineMissing, 0, 0},
+		{"missing package", "! LaTeX Error: File `fontspec.sty' not found.", failed, ErrCodeLatexMissingPackage, 0, 0},
+		{"unicode", "! Package inputenc Error: Unicode character ✓ (U+2713)\nnot set up for use with LaTeX. line 12, column 3", failed, ErrCodeLatexUnicode, 0, 0},
+		{"missing image", "[WARNING] Could not fetch resource missing.png", failed, ErrCodeResourceNotFound, 0, 0},
+		{"parse error", "Error parsing YAML metadata at line 4, column 7", failed, ErrCodeParseError, 4, 7},
+		{"other", "something broke", failed, ErrCodeConversionFailed, 0, 0},
+	}
+	for _, tt := range tests {
+		ce := classifyPandocError(context.Background(), tt.err, tt.stderr+"\n")
+		if ce.Code != tt.code || ce.Line != tt.line || ce.Column != tt.column {
+			t.Errorf("%s: code %s at %d:%d, want %s at %d:%d", tt.name, ce.Code, ce.Line, ce.Column, tt.code, tt.line, tt.column)
+		}
+		if ce.Hint != errorHints[tt.code] || ce.Detail != tt.stderr {
+			t.Errorf("%s: hint %q, detail %q", tt.name, ce.Hint, ce.Detail)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if ce := classifyPandocError(ctx, failed, "parse error"); ce.Code != ErrCodeTimeout {
+		t.Errorf("deadline exceeded classified as %s", ce.Code)
+	}
+}
+
+func TestAsConversionError(t *testing.T) {
+	ce := newConversionError(ErrCodeTooLarge, "too big")
+	if got := asConversionError(errors.Join(errors.New("wrapped"), ce)); got != ce {
+		t.Errorf("asConversionError lost the wrapped error: %+v", got)
+	}
+	if got := asConversionError(errors.New("disk full")); got.Code != ErrCodeConversionFailed || got.Message != "disk full" {
+		t.Errorf("asConversionError = %+v", got)
+	}
+}
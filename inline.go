@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Response modes of /api/convert, chosen with the response parameter or
+// an Accept header naming the output format
+const (
+	ResponseJob    = "job"    // JSON with the job ID; the output is downloaded separately
+	ResponseInline = "inline" // the converted document as the response body
+	ResponseEmbed  = "embed"  // JSON with a text output embedded as "content"
+)
+
+// maxEmbedBytes bounds outputs embedded in JSON; larger ones are left
+// for download
+const maxEmbedBytes = 4 << 20
+
+// responseMode resolves the response mode of a conversion request
+func responseMode(requested, accept, toFmt string) (string, error) {
+	switch requested {
+	case ResponseJob, ResponseInline, ResponseEmbed:
+		return requested, nil
+	case "":
+		if acceptFormat(accept) == toFmt {
+			return ResponseInline, nil
+		}
+		return ResponseJob, nil
+	}
+	return "", fmt.Errorf("Unknown response mode: %s", requested)
+}
+
+// outputContentType returns the media type of a conversion output
+func outputContentType(path string) string {
+	mediaType, ok := extensionMediaTypes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return "application/octet-stream"
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		mediaType += "; charset=utf-8"
+	}
+	return mediaType
+}
+
+// writeOutput decrypts a conversion output with the job key and sends it
+// as an attachment named name, with Range, conditional request and
+// Content-Length handling from http.ServeContent. It reports whether the
+// whole output was sent with a 200.
+func writeOutput(w http.ResponseWriter, r *http.Request, path, name string, key []byte) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return false
+	}
+	f, err := openEncrypted(path, key)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return false
+	}
+	defer f.Close()
+
+	// Outputs never change once written, so size and mtime identify them
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	w.Header().Set("Content-Type", outputContentType(path))
+	w.Header().Set("Content-Disposition", contentDisposition(name))
+	w.Header().Set("Cache-Control", "no-store")
+	sw := &sentWriter{ResponseWriter: w}
+	http.ServeContent(sw, r, name, info.ModTime(), f)
+	return sw.status == http.StatusOK && sw.sent == f.Size()
+}
+
+// sentWriter records the status and body size of a response
+type sentWriter struct {
+	http.ResponseWriter
+	status int
+	sent   int64
+}
+
+func (w *sentWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sentWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.sent += int64(n)
+	return n, err
+}
+
+// downloadName names an output after the uploaded file with the output's
+// extension, e.g. report.docx converted to PDF downloads as report.pdf
+func downloadName(original, outputPath string) string {
+	base := strings.TrimSuffix(filepath.Base(original), filepath.Ext(original))
+	if original == "" || base == "" || base == "." {
+		base = "converted"
+	}
+	return base + filepath.Ext(outputPath)
+}
+
+// contentDisposition builds an attachment header per RFC 6266: an ASCII
+// filename for old clients and an RFC 5987 filename* for the real name
+func contentDisposition(name string) string {
+	var fallback, encoded strings.Builder
+	exact := true
+	for _, r := range name {
+		if r < 0x20 || r >= utf8.RuneSelf || r == 0x7f || r == '"' || r == '\\' {
+			fallback.WriteByte('_')
+			exact = false
+		} else {
+			fallback.WriteRune(r)
+		}
+	}
+	header := `attachment; filename="` + fallback.String() + `"`
+	if exact {
+		return header
+	}
+
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return header + "; filename*=UTF-8''" + encoded.String()
+}
+
+// isAttrChar reports whether b may appear unencoded in an RFC 5987 value
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// readTextOutput returns an output as a string when it is text small
+// enough to embed in a JSON response
+func readTextOutput(toFmt, path string, key []byte) (string, bool) {
+	if binaryFormats[toFmt] || strings.HasSuffix(path, ".zip") {
+		return "", false
+	}
+	f, err := openEncrypted(path, key)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	if f.Size() > maxEmbedBytes {
+		return "", false
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || !utf8.Valid(data) {
+		return "", false
+	}
+	return string(data), true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseMode(t *testing.T) {
+	tests := []struct {
+		requested, accept, toFmt string
+		want                     string
+		ok                       bool
+	}{
+		{"", "", "html", ResponseJob, true},
+		{"", "application/json", "html", ResponseJob, true},
+		{"", "text/html", "html", ResponseInline, true},
+		{"", "application/pdf", "html", ResponseJob, true},
+		{"job", "text/html", "html", ResponseJob, true},
+		{"inline", "", "pdf", ResponseInline, true},
+		{"embed", "", "markdown", ResponseEmbed, true},
+		{"stream", "", "html", "", false},
+	}
+	for _, tt := range tests {
+		got, err := responseMode(tt.requested, tt.accept, tt.toFmt)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("responseMode(%q, %q, %q) = %q, %v, want %q, ok %v", tt.requested, tt.accept, tt.toFmt, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestOutputContentType(t *testing.T) {
+	tests := map[string]string{
+		"output.html": "text/html; charset=utf-8",
+		"output.pdf":  "application/pdf",
+		"output.txt":  "text/plain; charset=utf-8",
+		"output.zip":  "application/zip",
+		"output.docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"output.odt":  "application/vnd.oasis.opendocument.text",
+		"output.epub": "application/epub+zip",
+		"output.pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"output.rtf":  "application/rtf",
+		"output.md":   "text/markdown; charset=utf-8",
+		"output.tex":  "application/x-tex",
+		"output.rst":  "text/x-rst; charset=utf-8",
+		"output.org":  "text/org; charset=utf-8",
+		"output.xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"output.wiki": "application/octet-stream",
+	}
+	for path, want := range tests {
+		if got := outputContentType(path); got != want {
+			t.Errorf("outputContentType(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	// Inline responses are chosen by Accept, so the type sent for an output
+	// must name a format with that output's extension
+	for ext := range extensionFormats {
+		mediaType, _, _ := strings.Cut(outputContentType("output"+ext), ";")
+		if got := mediaTypeFormat(mediaType); mediaType != "application/octet-stream" && formatExtensions[got] != ext {
+			t.Errorf("%s output is sent as %s, which names %q", ext, mediaType, got)
+		}
+	}
+}
+
+func TestDownloadName(t *testing.T) {
+	tests := []struct {
+		original, output, want string
+	}{
+		{"report.docx", "/jobs/x/output.pdf", "report.pdf"},
+		{"dir/Über Bericht.md", "output.html", "Über Bericht.html"},
+		{"archive.tar.gz", "output.txt", "archive.tar.txt"},
+		{"", "output.pdf", "converted.pdf"},
+		{".md", "output.pdf", "converted.pdf"},
+		{"README", "output.html", "README.html"},
+	}
+	for _, tt := range tests {
+		if got := downloadName(tt.original, tt.output); got != tt.want {
+			t.Errorf("downloadName(%q, %q) = %q, want %q", tt.original, tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":       `attachment; filename="report.pdf"`,
+		"my report.pdf":    `attachment; filename="my report.pdf"`,
+		"Über Bericht.pdf": `attachment; filename="_ber Bericht.pdf"; filename*=UTF-8''%C3%9Cber%20Bericht.pdf`,
+		`say "hi".txt`:     `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`,
+		"a\r\nb.txt":       `attachment; filename="a__b.txt"; filename*=UTF-8''a%0D%0Ab.txt`,
+		"日本.md":            `attachment; filename="__.md"; filename*=UTF-8''%E6%97%A5%E6%9C%AC.md`,
+	}
+	for name, want := range tests {
+		if got := contentDisposition(name); got != want {
+			t.Errorf("contentDisposition(%q) =\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestDownloadConditionalAndRange(t *testing.T) {
+	const content = "0123456789abcdef"
+	entry := addDownloadJob(t, "range-job", content)
+	entry.DownloadName = "Résumé.txt"
+	token, hash := newOwnerToken()
+	entry.OwnerHash = hash
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/download?id=range-job", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handleDownload(w, r)
+		return w
+	}
+
+	w := get(nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != content || etag == "" {
+		t.Fatalf("GET: status %d, body %q, ETag %q", w.Code, w.Body, etag)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != contentDisposition("Résumé.txt") {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Error("ranges not advertised")
+	}
+
+	if w := get(http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	w = get(http.Header{"Range": {"bytes=10-"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != content[10:] || w.Header().Get("Content-Range") != "bytes 10-15/16" {
+		t.Errorf("Range: status %d, body %q, Content-Range %q", w.Code, w.Body, w.Header().Get("Content-Range"))
+	}
+	if w := get(http.Header{"Range": {"bytes=2-3"}, "If-Range": {`"stale"`}}); w.Code != http.StatusOK || w.Body.String() != content {
+		t.Errorf("stale If-Range: status %d, want the full file", w.Code)
+	}
+	if w := get(http.Header{"Range": {"bytes=99-"}}); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable Range: status %d, want 416", w.Code)
+	}
+}
+
+func TestDownloadDuringExpiry(t *testing.T) {
+	entry := addDownloadJob(t, "expiring-job", "content")
+	token, hash := newOwnerToken()
+	entry.OwnerHash = hash
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		jobStore.Lock()
+		entry.DownloadName = "renamed.txt"
+		shredKey(entry.Key)
+		jobStore.Unlock()
+	}()
+	r := httptest.NewRequest("GET", "/api/download?id=expiring-job", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handleDownload(w, r)
+	<-done
+
+	// Either the whole file or a clean failure, never a partial decryption
+	if w.Code == http.StatusOK && w.Body.String() != "content" {
+		t.Errorf("body %q", w.Body)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// officeFormats can be read by LibreOffice; officeTextFormats can also be
+// written, so pairs of them are converted office-to-office
+var (
+	officeFormats     = map[string]bool{"docx": true, "odt": true, "pptx": true, "rtf": true}
+	officeTextFormats = map[string]bool{"docx": true, "odt": true, "rtf": true}
+)
+
+// libreOfficeFilters maps target formats to soffice --convert-to filters
+var libreOfficeFilters = map[string]string{
+	"pdf":  "pdf",
+	"docx": "docx:MS Word 2007 XML",
+	"odt":  "odt",
+	"rtf":  "rtf:Rich Text Format",
+}
+
+// libreOfficeInput is the base name of the staged input; soffice names its
+// output after it
+const libreOfficeInput = "soffice-input"
+
+// libreOfficeConverter runs soffice --headless with a fresh profile inside
+// the job's work directory, so document history and caches are removed
+// with it. Instances bounds how many conversions run at once.
+type libreOfficeConverter struct {
+	binary string
+	slots  chan struct{} // one per idle instance
+}
+
+// newLibreOfficeConverter returns nil when LibreOffice is not installed.
+// The configured instance count sets how many conversions run in parallel.
+func newLibreOfficeConverter() *libreOfficeConverter {
+	var binary string
+	for _, name := range []string{"soffice", "libreoffice"} {
+		if path, err := exec.LookPath(name); err == nil {
+			binary = path
+			break
+		}
+	}
+	if binary == "" {
+		return nil
+	}
+
+	instances := cfg().LibreOffice.Instances
+	c := &libreOfficeConverter{
+		binary: binary,
+		slots:  make(chan struct{}, instances),
+	}
+	for i := 0; i < instances; i++ {
+		c.slots <- struct{}{}
+	}
+	log.Printf("LibreOffice backend enabled (%s, %d instance(s))", binary, instances)
+	return c
+}
+
+func (*libreOfficeConverter) Name() string { return BackendLibreOffice }
+
+func (*libreOfficeConverter) CanConvert(from, to string) bool {
+	if !officeFormats[from] {
+		return false
+	}
+	return to == "pdf" || (officeTextFormats[from] && officeTextFormats[to])
+}
+
+// Prefers claims office-to-office pairs, which LibreOffice converts with
+// far less layout loss than pandoc
+func (*libreOfficeConverter) Prefers(from, to string) bool {
+	return officeTextFormats[from] && officeTextFormats[to] && from != to
+}
+
+func (c *libreOfficeConverter) Convert(ctx context.Context, req ConvertRequest) (*ConvertResult, error) {
+	res := &ConvertResult{}
+
+	// Wait for an idle instance
+	select {
+	case <-c.slots:
+	case <-ctx.Done():
+		return res, newConversionError(ErrCodeTimeout, "Timed out waiting for a LibreOffice instance")
+	}
+	defer func() { c.slots <- struct{}{} }()
+
+	// soffice picks its import filter from the file extension. The staged
+	// name differs from the job's input, which may already sit in WorkDir.
+	inputPath := filepath.Join(req.WorkDir, libreOfficeInput+formatExtensions[req.FromFmt])
+	if err := linkOrCopy(req.InputPath, inputPath); err != nil {
+		return res, fmt.Errorf("failed to stage input: %w", err)
+	}
+	outDir := filepath.Join(req.WorkDir, "out")
+	profile := filepath.Join(req.WorkDir, "soffice-profile")
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg().LibreOffice.Timeout.Std())
+	defer cancel()
+
+	cmd := sandboxCommand(runCtx, req.WorkDir, c.binary,
+		"-env:UserInstallation=file://"+filepath.ToSlash(profile),
+		"--headless", "--norestore", "--nolockcheck", "--nodefault", "--nofirststartwizard",
+		"--convert-to", libreOfficeFilters[req.ToFmt],
+		"--outdir", outDir,
+		inputPath,
+	)
+	out, err := cmd.CombinedOutput()
+	detail := strings.TrimSpace(string(out))
+
+	if err != nil {
+		var ce *ConversionError
+		switch {
+		case runCtx.Err() != nil:
+			ce = newConversionError(ErrCodeTimeout, "LibreOffice conversion timed out")
+		case errors.Is(err, exec.ErrNotFound):
+			return res, fmt.Errorf("%w: %v", errBackendUnavailable, err)
+		default:
+			ce = newConversionError(ErrCodeConversionFailed, fmt.Sprintf("LibreOffice conversion failed: %v", err))
+		}
+		ce.Detail = detail
+		return res, ce
+	}
+
+	// soffice exits zero even when it could not load the input
+	converted := filepath.Join(outDir, libreOfficeInput+formatExtensions[req.ToFmt])
+	if _, err := os.Stat(converted); err != nil {
+		ce := newConversionError(ErrCodeParseError, "LibreOffice could not convert the input document")
+		ce.Detail = detail
+		return res, ce
+	}
+	if err := os.Rename(converted, req.OutputPath); err != nil {
+		if err := linkOrCopy(converted, req.OutputPath); err != nil {
+			return res, fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// linkOrCopy hard-links src to dst, copying when linking isn't possible
+func linkOrCopy(src, dst string) error {
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
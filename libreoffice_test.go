@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSoffice creates its profile directory and copies its input to
+// --outdir, named as soffice names output
+const fakeSoffice = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -env:UserInstallation=file://*) mkdir -p "${1#-env:UserInstallation=file://}";;
+    --convert-to) ext="${2%%:*}"; shift;;
+    --outdir) out="$2"; shift;;
+    -*) ;;
+    *) in="$1";;
+  esac
+  shift
+done
+mkdir -p "$out"
+base=$(basename "$in")
+cp "$in" "$out/${base%.*}.$ext"
+`
+
+func TestLibreOfficeStagesInputInWorkDir(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "soffice")
+	if err := os.WriteFile(bin, []byte(fakeSoffice), 0700); err != nil {
+		t.Fatal(err)
+	}
+	c := &libreOfficeConverter{binary: bin, slots: make(chan struct{}, 1)}
+	c.slots <- struct{}{}
+
+	// processJob decrypts the input into the work directory itself
+	workDir := t.TempDir()
+	inputPath := filepath.Join(workDir, "input.docx")
+	if err := os.WriteFile(inputPath, []byte("document"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	req := ConvertRequest{
+		JobID:      "lo-test",
+		InputPath:  inputPath,
+		FromFmt:    "docx",
+		ToFmt:      "odt",
+		OutputPath: filepath.Join(workDir, "output.odt"),
+		WorkDir:    workDir,
+	}
+	if _, err := c.Convert(context.Background(), req); err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	got, err := os.ReadFile(req.OutputPath)
+	if err != nil || string(got) != "document" {
+		t.Errorf("output = %q, %v; want the input's content", got, err)
+	}
+	// The profile lives in the work directory and goes with it
+	if _, err := os.Stat(filepath.Join(workDir, "soffice-profile")); err != nil {
+		t.Errorf("profile not created in the work directory: %v", err)
+	}
+}
@@ -1,18 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,12 +31,24 @@ type Job struct {
 	ToFmt      string
 	Content    string
 	IsFile     bool
+	Charset    string
+	PDFEngine  string
+	Backend    string
+	Sheet      SheetOptions
+	Filename   string // original upload name, used to name the output
+	Size       int64  // input bytes
+	Key        []byte // encrypts the stored input and output
+	WorkDir    string // isolated directory for converter processes
+	EnqueuedAt time.Time
 	ResultChan chan Result
 }
 
 // Result represents the result of a conversion job
 type Result struct {
 	OutputPath string
+	Encoding   string
+	Warnings   []Warning
+	Timings    *JobTimings
 	Err        error
 }
 
@@ -50,10 +64,23 @@ const (
 
 // JobEntry represents a stored job entry
 type JobEntry struct {
-	Status     JobStatus
-	OutputPath string
-	Error      string
-	CreatedAt  time.Time
+	Status       JobStatus
+	OutputPath   string
+	Error        string
+	ErrorInfo    *ConversionError
+	FromFmt      string
+	ToFmt        string
+	Detection    *Detection
+	Encoding     string
+	PDFEngine    string
+	PDFAttempts  []PDFAttempt
+	Warnings     []Warning
+	Timings      *JobTimings
+	DownloadName string
+	Key          []byte            // encrypts the stored files; zeroed when the job goes
+	OwnerHash    [sha256.Size]byte // hash of the owner token
+	UsedLinks    map[string]bool   // nonces of single-use links already used
+	CreatedAt    time.Time
 }
 
 // JobStore holds jobs in memory with thread-safe access
@@ -62,10 +89,7 @@ type JobStore struct {
 	jobs map[string]*JobEntry
 }
 
-var (
-	jobQueue = make(chan Job, 256)
-	jobStore = JobStore{jobs: make(map[string]*JobEntry)}
-)
+var jobStore = JobStore{jobs: make(map[string]*JobEntry)}
 
 // Format extension mapping
 var formatExtensions = map[string]string{
@@ -96,6 +120,9 @@ var formatExtensions = map[string]string{
 	"creole":    ".txt",
 	"gfm":       ".md",
 	"pptx":      ".pptx",
+	"xlsx":      ".xlsx",
+	"ods":       ".ods",
+	"tsv":       ".tsv",
 }
 
 // Extension to format mapping (for auto-detection)
@@ -122,13 +149,17 @@ var extensionFormats = map[string]string{
 	".fb2":       "fb2",
 	".pptx":      "pptx",
 	".pdf":       "pdf",
+	".xlsx":      "xlsx",
+	".ods":       "ods",
 }
 
-// INPUT formats - what Pandoc can read from (high-demand first)
+// INPUT formats - what Pandoc can read from (high-demand first).
+// PDF and spreadsheets are converted to HTML by nativeReaders first.
 var inputFormats = []string{
 	// High-demand input formats
 	"markdown", "html", "docx", "gfm", "rst",
 	"latex", "odt", "plain", "epub", "mediawiki",
+	"pdf", "xlsx", "ods",
 	// Additional input formats
 	"org", "ipynb", "csv", "json", "rtf",
 	"textile", "docbook", "jira", "opml", "fb2",
@@ -144,6 +175,46 @@ var outputFormats = []string{
 	"epub", "mediawiki", "json", "org", "asciidoc",
 	"rtf", "textile", "docbook", "jira", "ipynb",
 	"opml", "fb2", "vimwiki",
+	// Table extraction (see nativeWriters)
+	"csv", "tsv", "xlsx",
+}
+
+// Native readers turn inputs Pandoc cannot read into an HTML document
+var nativeReaders = map[string]func(ctx context.Context, job Job, inputPath, outPath string) error{
+	"pdf": func(ctx context.Context, job Job, inputPath, outPath string) error {
+		return extractPDF(ctx, job.WorkDir, inputPath, outPath)
+	},
+	"xlsx": readSpreadsheet,
+	"ods":  readSpreadsheet,
+}
+
+// nativeWriter produces an output from Pandoc's JSON AST of the input
+type nativeWriter struct {
+	Ext   string
+	Write func(jsonPath, outPath string) error
+}
+
+// Native writers extract every table of a document as data
+var nativeWriters = map[string]nativeWriter{
+	"csv":  {Ext: ".zip", Write: tableWriter(',', ".csv")},
+	"tsv":  {Ext: ".zip", Write: tableWriter('\t', ".tsv")},
+	"xlsx": {Ext: ".xlsx", Write: func(jsonPath, outPath string) error {
+		tables, err := readTablesFromAST(jsonPath)
+		if err != nil {
+			return err
+		}
+		return writeTablesXLSX(tables, outPath)
+	}},
+}
+
+func tableWriter(comma rune, ext string) func(jsonPath, outPath string) error {
+	return func(jsonPath, outPath string) error {
+		tables, err := readTablesFromAST(jsonPath)
+		if err != nil {
+			return err
+		}
+		return writeTablesDelimited(tables, outPath, comma, ext)
+	}
 }
 
 // For backward compatibility, keep supportedFormats as all unique formats
@@ -152,7 +223,7 @@ var supportedFormats = []string{
 	"rst", "latex", "odt", "plain", "epub", "mediawiki",
 	"json", "org", "asciidoc", "csv", "rtf", "textile",
 	"docbook", "jira", "ipynb", "opml", "fb2", "vimwiki",
-	"twiki", "tikiwiki", "creole",
+	"twiki", "tikiwiki", "creole", "xlsx", "ods", "tsv",
 }
 
 // SEO landing page data
@@ -496,6 +567,32 @@ const seoTemplate = `<!DOCTYPE html>
 </html>`
 
 func main() {
+	// Launcher for sandboxed converter processes
+	if len(os.Args) > 1 && os.Args[1] == sandboxExecArg {
+		sandboxExec(os.Args[2:])
+	}
+
+	// Load and validate configuration
+	initConfig()
+
+	// Reload non-structural settings on SIGHUP
+	watchReload()
+
+	// Detect installed PDF engines once
+	detectPDFEngines()
+
+	// Check whether pandoc can sandbox document I/O
+	detectSandboxSupport()
+
+	// Start the pandoc server backend if enabled
+	startPandocServer()
+
+	// Register conversion backends
+	registerConverters()
+
+	// Remove job directories left by a previous run
+	sweepWorkDir()
+
 	// Start worker pool
 	startWorkers()
 
@@ -507,24 +604,33 @@ func main() {
 
 	// API routes
 	mux.HandleFunc("/api/convert", handleConvert)
+	mux.HandleFunc("/api/uploads", handleUploads)
+	mux.HandleFunc("/api/uploads/", handleUploads)
 	mux.HandleFunc("/api/download", handleDownload)
+	mux.HandleFunc("/api/download/link", handleDownloadLink)
+	mux.HandleFunc("/api/status", handleStatus)
 	mux.HandleFunc("/api/formats", handleFormats)
+	mux.HandleFunc("/api/metrics", handleMetrics)
 	mux.HandleFunc("/ping", handlePing)
 
+	// Admin routes
+	mux.HandleFunc("/api/admin/config", handleAdminConfig)
+
 	// SEO landing pages
 	mux.HandleFunc("/convert/", handleSEOLanding)
 
 	// Static files
-	mux.Handle("/", http.FileServer(http.Dir("./static")))
+	mux.Handle("/", http.FileServer(http.Dir(cfg().StaticDir)))
 
 	// Build middleware chain
-	handler := withHeaders(withGzip(cors.Default().Handler(mux)))
+	handler := withHeaders(withGzip(cors.New(cors.Options{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodHead, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: append([]string{"Accept", "Authorization", "Content-Type", "X-Requested-With"}, tusHeaders...),
+		ExposedHeaders: append([]string{"Location", "Content-Disposition", "X-Job-Id", "X-Owner-Token", "X-Warning-Count"}, tusHeaders...),
+	}).Handler(mux)))
 
 	// Configure server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	port := strconv.Itoa(cfg().Port)
 
 	server := &http.Server{
 		Addr:           ":" + port,
@@ -554,24 +660,44 @@ func withHeaders(next http.Handler) http.Handler {
 // withGzip adds gzip compression
 func withGzip(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		// Only GET and POST responses have bodies worth compressing
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") ||
+			(r.Method != http.MethodGet && r.Method != http.MethodPost) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		w.Header().Set("Content-Encoding", "gzip")
 		gz := gzip.NewWriter(w)
-		defer gz.Close()
-
 		gzw := &gzipWriter{Writer: gz, ResponseWriter: w}
 		next.ServeHTTP(gzw, r)
+		if gzw.start(); !gzw.passthrough {
+			gz.Close()
+		}
 	})
 }
 
-// gzipWriter wraps gzip.Writer for http.ResponseWriter
+// gzipWriter wraps gzip.Writer for http.ResponseWriter. Responses that
+// support Range requests are passed through uncompressed, since their
+// lengths and byte ranges refer to the file as stored.
 type gzipWriter struct {
 	Writer          *gzip.Writer
 	ResponseWriter  http.ResponseWriter
+	started         bool
+	passthrough     bool
+}
+
+// start decides on compression once the handler has set its headers
+func (g *gzipWriter) start() {
+	if g.started {
+		return
+	}
+	g.started = true
+	if g.ResponseWriter.Header().Get("Accept-Ranges") != "" {
+		g.passthrough = true
+		return
+	}
+	g.ResponseWriter.Header().Set("Content-Encoding", "gzip")
+	g.ResponseWriter.Header().Del("Content-Length")
 }
 
 func (g *gzipWriter) Header() http.Header {
@@ -579,232 +705,454 @@ func (g *gzipWriter) Header() http.Header {
 }
 
 func (g *gzipWriter) Write(b []byte) (int, error) {
+	g.start()
+	if g.passthrough {
+		return g.ResponseWriter.Write(b)
+	}
 	return g.Writer.Write(b)
 }
 
 func (g *gzipWriter) WriteHeader(statusCode int) {
+	g.start()
 	g.ResponseWriter.WriteHeader(statusCode)
 }
 
-// startWorkers spawns the worker pool
-func startWorkers() {
-	for i := 0; i < 8; i++ {
-		go func() {
-			for job := range jobQueue {
-				processJob(job)
-			}
-		}()
-	}
-}
-
 // startCleanup runs periodic cleanup of old jobs
 func startCleanup() {
-	ticker := time.NewTicker(10 * time.Minute)
 	go func() {
-		for range ticker.C {
+		for {
+			time.Sleep(cfg().CleanupInterval.Std())
 			cleanupOldJobs()
+			cleanupExpiredUploads()
 		}
 	}()
 }
 
-// cleanupOldJobs removes jobs older than 30 minutes
+// cleanupOldJobs removes finished jobs older than the retention period.
+// Queued and running jobs are kept until they finish.
 func cleanupOldJobs() {
 	jobStore.Lock()
-	defer jobStore.Unlock()
-
 	now := time.Now()
+	retention := cfg().JobRetention.Std()
+	var expired []string
 	for id, entry := range jobStore.jobs {
-		if now.Sub(entry.CreatedAt) > 30*time.Minute {
-			// Delete output file if exists
-			if entry.OutputPath != "" {
-				os.Remove(entry.OutputPath)
-			}
+		if entry.Status == StatusQueued || entry.Status == StatusProcessing {
+			continue
+		}
+		if now.Sub(entry.CreatedAt) > retention {
+			shredKey(entry.Key)
 			delete(jobStore.jobs, id)
+			expired = append(expired, id)
 		}
 	}
+	jobStore.Unlock()
+
+	// Delete the job directories with their input and output
+	for _, id := range expired {
+		removeJobDir(id)
+	}
+}
+
+// updateJob changes a job's entry under the store lock, unless the job
+// has been removed meanwhile
+func updateJob(id string, update func(e *JobEntry)) {
+	jobStore.Lock()
+	defer jobStore.Unlock()
+	if e, ok := jobStore.jobs[id]; ok {
+		update(e)
+	}
 }
 
-// processJob processes a single conversion job
-func processJob(job Job) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+// processJob processes a single conversion job within its class's timeout
+func processJob(job Job, class string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	startedAt := time.Now()
+
 	// Update job status
-	jobStore.Lock()
-	jobStore.jobs[job.ID].Status = StatusProcessing
-	jobStore.Unlock()
+	updateJob(job.ID, func(e *JobEntry) { e.Status = StatusProcessing })
 
 	result := Result{}
 
+	// Converters run in a scratch directory, on tmpfs where available, with
+	// HOME pointing into it. It holds the only plaintext copies of the
+	// input and output and is removed as soon as the conversion ends.
+	jobDir := jobDirPath(job.ID)
+	// An empty scratch_dir would put plaintext in the system temp directory
+	if cfg().ScratchDir == "" {
+		failJob(job, result, errors.New("no scratch directory is configured"))
+		return
+	}
+	workDir, err := os.MkdirTemp(cfg().ScratchDir, scratchPrefix)
+	if err != nil {
+		failJob(job, result, fmt.Errorf("failed to create work directory: %w", err))
+		return
+	}
+	defer os.RemoveAll(workDir)
+	job.WorkDir = workDir
+
 	// Prepare input/output paths
-	var inputPath string
+	inputPath := filepath.Join(workDir, "input"+formatExtensions[job.FromFmt])
 	if job.IsFile {
-		inputPath = job.InputPath
-		defer os.Remove(inputPath)
+		inputPath = filepath.Join(workDir, filepath.Base(job.InputPath))
+		err = decryptFile(job.InputPath, inputPath, job.Key)
+		os.Remove(job.InputPath)
 	} else {
-		// Create temp file from content
-		ext := formatExtensions[job.FromFmt]
-		tmpFile, err := os.CreateTemp("", "pandoc_upload_*"+ext)
+		err = os.WriteFile(inputPath, []byte(job.Content), 0600)
+	}
+	if err != nil {
+		failJob(job, result, fmt.Errorf("failed to prepare input: %w", err))
+		return
+	}
+
+	// Pandoc only reads UTF-8 - transcode uploaded text inputs first
+	if job.IsFile && !binaryFormats[job.FromFmt] {
+		enc, err := transcodeFile(inputPath, job.Charset)
 		if err != nil {
-			result.Err = fmt.Errorf("failed to create temp file: %w", err)
-			job.ResultChan <- result
+			ce := newConversionError(ErrCodeParseError, fmt.Sprintf("failed to decode input as %s: %v", enc, err))
+			ce.Hint = "Specify the input's character encoding with the charset parameter."
+			failJob(job, result, ce)
 			return
 		}
-		inputPath = tmpFile.Name()
-		if _, err := tmpFile.WriteString(job.Content); err != nil {
-			tmpFile.Close()
-			result.Err = fmt.Errorf("failed to write content: %w", err)
-			job.ResultChan <- result
+		result.Encoding = enc
+		updateJob(job.ID, func(e *JobEntry) { e.Encoding = enc })
+	}
+
+	// Formats Pandoc cannot read are converted to HTML by a native reader first
+	fromFmt := job.FromFmt
+	if reader, ok := nativeReaders[fromFmt]; ok {
+		htmlPath := inputPath + ".html"
+		if err := reader(ctx, job, inputPath, htmlPath); err != nil {
+			var ce *ConversionError
+			if !errors.As(err, &ce) {
+				ce = newConversionError(ErrCodeParseError, err.Error())
+			}
+			failJob(job, result, ce)
+			os.Remove(htmlPath)
 			return
 		}
-		tmpFile.Close()
-		defer os.Remove(inputPath)
+		defer os.Remove(htmlPath)
+		inputPath = htmlPath
+		fromFmt = "html"
 	}
 
 	// Prepare output path
 	outExt := formatExtensions[job.ToFmt]
-	outputPath := filepath.Join(os.TempDir(), "pandoc_output_"+job.ID+outExt)
-
-	// Build pandoc command with improved flags
-	args := []string{
-		inputPath,
-		"-f", job.FromFmt,
-		"-t", job.ToFmt,
-		"--standalone", // Create complete documents (fixes DOCX issues)
-		"--wrap=none",  // Prevent unwanted line wrapping
-	}
-
-	// Add PDF-specific options - try multiple engines in order of preference
-	if job.ToFmt == "pdf" {
-		// Check which PDF engines are available
-		pdfEngines := []string{"xelatex", "pdflatex", "luatex"}
-		selectedEngine := ""
-
-		for _, engine := range pdfEngines {
-			if _, err := exec.LookPath(engine); err == nil {
-				selectedEngine = engine
-				break
-			}
-		}
+	if writer, ok := nativeWriters[job.ToFmt]; ok {
+		outExt = writer.Ext
+	}
+	outputPath := filepath.Join(jobDir, "output"+outExt)
+
+	req := ConvertRequest{
+		JobID:      job.ID,
+		InputPath:  inputPath,
+		FromFmt:    fromFmt,
+		ToFmt:      job.ToFmt,
+		OutputPath: filepath.Join(workDir, "output"+outExt),
+		PDFEngine:  job.PDFEngine,
+		WorkDir:    workDir,
+	}
 
-		if selectedEngine == "" {
-			// No PDF engine available - fail fast with clear error
-			result.Err = fmt.Errorf("PDF conversion requires a LaTeX engine (xelatex, pdflatex, or luatex) to be installed. Please install texlive-latex-recommended and lmodern packages")
-			job.ResultChan <- result
+	convertStart := time.Now()
 
-			jobStore.Lock()
-			jobStore.jobs[job.ID].Status = StatusFailed
-			jobStore.jobs[job.ID].Error = result.Err.Error()
-			jobStore.Unlock()
-			os.Remove(inputPath)
+	// Remote images are embedded or removed according to the resource policy
+	var policyWarnings []Warning
+	if needsResourcePolicy(req.FromFmt, req.ToFmt, req.InputPath) {
+		var err error
+		req, policyWarnings, err = applyResourcePolicy(ctx, req)
+		if err != nil {
+			failJob(job, result, err)
 			return
 		}
+		defer os.Remove(req.InputPath)
+	}
 
-		args = append(args, "--pdf-engine="+selectedEngine)
+	// Route to the preferred backend, moving on only if one is unavailable
+	preferred := preferredBackend(job.Backend, req.FromFmt, req.ToFmt)
+	candidates := routeConverters(req.FromFmt, req.ToFmt, preferred)
+	if len(candidates) == 0 {
+		failJob(job, result, newConversionError(ErrCodeUnknownFormat, fmt.Sprintf("No converter available for %s to %s", req.FromFmt, req.ToFmt)))
+		return
 	}
 
-	// Output file must be last
-	args = append(args, "-o", outputPath)
+	var (
+		backend string
+		res     *ConvertResult
+	)
+	for _, c := range candidates {
+		backend = c.Name()
+		res, err = c.Convert(ctx, req)
+		if !errors.Is(err, errBackendUnavailable) {
+			break
+		}
+		log.Printf("Job %s: %s backend unavailable: %v", job.ID, backend, err)
+	}
 
-	cmd := exec.CommandContext(ctx, "pandoc", args...)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	if res != nil && len(res.PDFAttempts) > 0 {
+		updateJob(job.ID, func(e *JobEntry) {
+			e.PDFAttempts = res.PDFAttempts
+			e.PDFEngine = res.PDFEngine
+		})
+	}
 
-	if err := cmd.Run(); err != nil {
-		result.Err = fmt.Errorf("pandoc failed: %w, stderr: %s", err, stderr.String())
-		job.ResultChan <- result
+	if err != nil {
+		failJob(job, result, err)
+		return
+	}
+	result.Warnings = append(policyWarnings, res.Warnings...)
 
-		// Update job status
-		jobStore.Lock()
-		jobStore.jobs[job.ID].Status = StatusFailed
-		jobStore.jobs[job.ID].Error = result.Err.Error()
-		jobStore.Unlock()
+	// Only the encrypted output outlives the scratch directory
+	if err := encryptFile(req.OutputPath, outputPath, job.Key); err != nil {
+		failJob(job, result, fmt.Errorf("failed to store output: %w", err))
 		return
 	}
+	os.RemoveAll(workDir)
+
+	convertDuration := time.Since(convertStart)
+	recordBackendTiming(backend, convertDuration)
+	timings := &JobTimings{
+		Backend:   backend,
+		Class:     class,
+		QueueMs:   startedAt.Sub(job.EnqueuedAt).Milliseconds(),
+		ConvertMs: convertDuration.Milliseconds(),
+		TotalMs:   time.Since(job.EnqueuedAt).Milliseconds(),
+	}
 
 	result.OutputPath = outputPath
+	result.Timings = timings
+
+	// Update job status before handing over the result, so a client that
+	// gets the response can download immediately
+	updateJob(job.ID, func(e *JobEntry) {
+		e.Status = StatusDone
+		e.OutputPath = outputPath
+		e.Warnings = result.Warnings
+		e.Timings = timings
+		e.DownloadName = downloadName(job.Filename, outputPath)
+	})
+
 	job.ResultChan <- result
+}
 
-	// Update job status
-	jobStore.Lock()
-	jobStore.jobs[job.ID].Status = StatusDone
-	jobStore.jobs[job.ID].OutputPath = outputPath
-	jobStore.Unlock()
+// failJob reports a failed job to the waiting handler and the job store
+func failJob(job Job, result Result, err error) {
+	result.Err = err
+
+	updateJob(job.ID, func(e *JobEntry) {
+		e.Status = StatusFailed
+		e.Error = err.Error()
+		e.ErrorInfo = asConversionError(err)
+		shredKey(e.Key)
+	})
+
+	// A failed job has nothing to download
+	removeJobDir(job.ID)
+	job.ResultChan <- result
 }
 
 // handleConvert handles conversion requests
 func handleConvert(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	w.Header().Set("Cache-Control", "no-store")
 
+	// Refuse oversized bodies before reading them, and cap the rest
+	bodyLimit := maxUploadLimit() + uploadOverhead
+	if r.ContentLength > bodyLimit {
+		writeTooLarge(w, &tooLargeError{limit: maxUploadLimit()})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, bodyLimit)
+
 	var job Job
 	job.ID = uuid.New().String()
 	job.ResultChan = make(chan Result, 1)
 
-	contentType := r.Header.Get("Content-Type")
+	// Evict old results first if the work directory is over its quota
+	if !enforceDiskQuota() {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Server storage is full, try again later", http.StatusInsufficientStorage)
+		return
+	}
 
-	if strings.HasPrefix(contentType, "multipart/form-data") {
-		// File upload
-		if err := r.ParseMultipartForm(32 << 20); err != nil {
-			http.Error(w, "Failed to parse form", http.StatusBadRequest)
-			return
+	// Everything the job stores lives in its directory, removed unless the
+	// job is accepted
+	jobDir, err := createJobDir(job.ID)
+	if err != nil {
+		http.Error(w, "Failed to create job directory", http.StatusInternalServerError)
+		return
+	}
+	job.Key = newJobKey()
+	accepted := false
+	defer func() {
+		if !accepted {
+			shredKey(job.Key)
+			removeJobDir(job.ID)
 		}
+	}()
 
-		file, header, err := r.FormFile("file")
+	mediaType, contentParams, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var detection *Detection
+	var inputSize int64
+	var uploadID string // resumable upload used as input, removed once accepted
+	var requestedMode string
+
+	if mediaType == "multipart/form-data" {
+		// File upload, streamed to the job directory
+		form, file, err := readMultipartUpload(r, jobDir, job.Key)
+		if tl, ok := asTooLarge(err); ok {
+			writeTooLarge(w, tl)
+			return
+		}
 		if err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+		if file == nil && form.Get("upload_id") != "" {
+			uploadID = form.Get("upload_id")
+			if file, err = claimUpload(uploadID, r, jobDir, job.Key); err != nil {
+				http.Error(w, "Invalid upload_id: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if file == nil {
 			http.Error(w, "No file provided", http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
-		// Save uploaded file
-		ext := filepath.Ext(header.Filename)
-		tmpFile, err := os.CreateTemp("", "pandoc_upload_*"+ext)
-		if err != nil {
-			http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
+		ext := filepath.Ext(file.Filename)
+		job.InputPath = file.Path
+		job.Filename = file.Filename
+		job.IsFile = true
+		inputSize = file.Size
+		job.FromFmt = form.Get("from")
+		job.ToFmt = form.Get("to")
+		requestedMode = form.Get("response")
+
+		if err := parseFileOptions(&job, form); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer tmpFile.Close()
 
-		if _, err := io.Copy(tmpFile, file); err != nil {
+		// Auto-detect from format if not provided
+		if job.FromFmt == "" {
+			preferContent := form.Get("sniff") == "true"
+			d := detectStoredFormat(job.InputPath, job.Key, ext, preferContent)
+			job.FromFmt = d.Format
+			detection = &d
+		}
+	} else if isRawRequest(r, mediaType) {
+		// Raw body: the input format comes from Content-Type and the
+		// output format from the query or the Accept header
+		form := r.URL.Query()
+		job.FromFmt = form.Get("from")
+		if job.FromFmt == "" {
+			if format := mediaTypeFormat(mediaType); format != "" {
+				job.FromFmt = format
+				detection = &Detection{Format: format, Confidence: confidenceMedium, Source: "content_type"}
+			}
+		}
+
+		file, err := saveRawUpload(r, jobDir, job.FromFmt, job.Key)
+		if tl, ok := asTooLarge(err); ok {
+			writeTooLarge(w, tl)
+			return
+		}
+		if err != nil {
 			http.Error(w, "Failed to save file", http.StatusInternalServerError)
 			return
 		}
-
-		job.InputPath = tmpFile.Name()
+		job.InputPath = file.Path
+		job.Filename = file.Filename
 		job.IsFile = true
-		job.FromFmt = r.FormValue("from")
-		job.ToFmt = r.FormValue("to")
+		inputSize = file.Size
 
-		// Auto-detect from format if not provided
+		job.ToFmt = form.Get("to")
+		requestedMode = form.Get("response")
+		if job.ToFmt == "" {
+			job.ToFmt = acceptFormat(r.Header.Get("Accept"))
+		}
+		if form.Get("charset") == "" && contentParams["charset"] != "" {
+			form.Set("charset", contentParams["charset"])
+		}
+		if err := parseFileOptions(&job, form); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Without a usable Content-Type, detect from the content
 		if job.FromFmt == "" {
-			if fmt, ok := extensionFormats[ext]; ok {
-				job.FromFmt = fmt
-			} else {
-				job.FromFmt = "markdown"
-			}
+			d := detectStoredFormat(job.InputPath, job.Key, filepath.Ext(file.Filename), true)
+			job.FromFmt = d.Format
+			detection = &d
 		}
 	} else {
 		// JSON content
 		var data struct {
-			FromFmt string `json:"from"`
-			ToFmt   string `json:"to"`
-			Content string `json:"content"`
+			FromFmt   string `json:"from"`
+			ToFmt     string `json:"to"`
+			Content   string `json:"content"`
+			Sniff     bool   `json:"sniff"`
+			PDFEngine string `json:"pdf_engine"`
+			Backend   string `json:"backend"`
+			UploadID  string `json:"upload_id"`
+			Response  string `json:"response"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			if tl, ok := asTooLarge(err); ok {
+				writeTooLarge(w, tl)
+				return
+			}
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
 
 		job.Content = data.Content
+		inputSize = int64(len(data.Content))
 		job.FromFmt = data.FromFmt
 		job.ToFmt = data.ToFmt
+		job.PDFEngine = data.PDFEngine
+		job.Backend = data.Backend
 		job.IsFile = false
+		requestedMode = data.Response
+		if requestedMode == "" {
+			requestedMode = r.URL.Query().Get("response")
+		}
+
+		// A completed resumable upload replaces inline content
+		if data.UploadID != "" {
+			uploadID = data.UploadID
+			file, err := claimUpload(uploadID, r, jobDir, job.Key)
+			if err != nil {
+				http.Error(w, "Invalid upload_id: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			job.Content = ""
+			job.InputPath = file.Path
+			job.Filename = file.Filename
+			job.IsFile = true
+			inputSize = file.Size
+			if job.FromFmt == "" {
+				d := detectStoredFormat(file.Path, job.Key, filepath.Ext(file.Filename), data.Sniff)
+				job.FromFmt = d.Format
+				detection = &d
+			}
+		}
+
+		// Detect from content only when the client opts in
+		if job.FromFmt == "" && data.Sniff {
+			format, conf := sniffBytes([]byte(data.Content))
+			if format == "" {
+				format = "markdown"
+			}
+			detection = &Detection{Format: format, Confidence: conf, Source: "content"}
+			job.FromFmt = format
+		}
 	}
 
 	// Validate formats
@@ -813,24 +1161,78 @@ func handleConvert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create job entry
+	mode, err := responseMode(requestedMode, r.Header.Get("Accept"), job.ToFmt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if limit := uploadLimit(job.FromFmt); inputSize > limit {
+		writeTooLarge(w, &tooLargeError{format: job.FromFmt, limit: limit})
+		return
+	}
+
+	if job.PDFEngine != "" && !isPDFEngineAvailable(job.PDFEngine) {
+		http.Error(w, "PDF engine not available: "+job.PDFEngine, http.StatusBadRequest)
+		return
+	}
+
+	// Optional backends are only registered when enabled at startup
+	if job.Backend != "" && converterByName(job.Backend) == nil {
+		msg := "Unknown backend: "
+		if job.Backend == BackendServer || job.Backend == BackendLibreOffice {
+			msg = "Backend not enabled on this server: "
+		}
+		http.Error(w, msg+job.Backend, http.StatusBadRequest)
+		return
+	}
+
+	// Shed load before accepting work that could exhaust memory
+	job.Size = inputSize
+	pool := pools[classifyJob(job)]
+	if pool.memoryPressure() {
+		pool.shed.Add(1)
+		w.Header().Set("Retry-After", strconv.Itoa(pool.retryAfter()))
+		http.Error(w, "Server is low on memory, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Create job entry, owned by whoever holds the token
+	ownerToken, ownerHash := newOwnerToken()
 	jobStore.Lock()
 	jobStore.jobs[job.ID] = &JobEntry{
+		OwnerHash: ownerHash,
+		Key:       job.Key,
 		Status:    StatusQueued,
+		FromFmt:   job.FromFmt,
+		ToFmt:     job.ToFmt,
+		Detection: detection,
 		CreatedAt: time.Now(),
 	}
 	jobStore.Unlock()
 
-	// Enqueue job
-	select {
-	case jobQueue <- job:
-	default:
+	// Enqueue job in the pool for its cost class
+	job.EnqueuedAt = time.Now()
+	if !pool.enqueue(job) {
+		jobStore.Lock()
+		delete(jobStore.jobs, job.ID)
+		jobStore.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(pool.retryAfter()))
 		http.Error(w, "Queue full, try again later", http.StatusServiceUnavailable)
 		return
 	}
+	accepted = true
+	if uploadID != "" {
+		removeUpload(uploadID)
+	}
 
-	// Wait for result with timeout
-	ctx, cancel := context.WithTimeout(r.Context(), 65*time.Second)
+	// Wait for the result; inline modes give up after a short deadline
+	// and answer with the job instead
+	wait := pool.timeout() + cfg().ResponseGrace.Std()
+	if mode != ResponseJob {
+		wait = min(wait, cfg().InlineDeadline.Std())
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
 	defer cancel()
 
 	select {
@@ -838,30 +1240,109 @@ func handleConvert(w http.ResponseWriter, r *http.Request) {
 		if result.Err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error":  result.Err.Error(),
-				"job_id": job.ID,
+				"error":       result.Err.Error(),
+				"error_info":  asConversionError(result.Err),
+				"job_id":      job.ID,
+				"owner_token": ownerToken,
+				"detection":   detection,
+				"encoding":    result.Encoding,
 			})
 			return
 		}
 
+		if mode == ResponseInline {
+			w.Header().Set("X-Job-Id", job.ID)
+			w.Header().Set("X-Owner-Token", ownerToken)
+			w.Header().Set("X-Warning-Count", strconv.Itoa(len(result.Warnings)))
+			writeOutput(w, r, result.OutputPath, downloadName(job.Filename, result.OutputPath), job.Key)
+			return
+		}
+
+		resp := map[string]interface{}{
+			"job_id":      job.ID,
+			"owner_token": ownerToken,
+			"status":      "done",
+			"detection":   detection,
+			"encoding":    result.Encoding,
+			"warnings":    result.Warnings,
+			"timings":     result.Timings,
+		}
+		if mode == ResponseEmbed {
+			if content, ok := readTextOutput(job.ToFmt, result.OutputPath, job.Key); ok {
+				resp["content"] = content
+			}
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"job_id": job.ID,
-			"status": "done",
-		})
+		json.NewEncoder(w).Encode(resp)
 
 	case <-ctx.Done():
+		if mode != ResponseJob && r.Context().Err() == nil {
+			// Still running: the client polls /api/status and downloads
+			status := StatusProcessing
+			jobStore.RLock()
+			if entry, ok := jobStore.jobs[job.ID]; ok {
+				status = entry.Status
+			}
+			jobStore.RUnlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"job_id":      job.ID,
+				"owner_token": ownerToken,
+				"status":      status,
+				"detection":   detection,
+			})
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":  "Conversion timeout",
-			"job_id": job.ID,
+			"error":       "Conversion timeout",
+			"error_info":  newConversionError(ErrCodeTimeout, "Conversion timeout"),
+			"job_id":      job.ID,
+			"owner_token": ownerToken,
 		})
 	}
 }
 
+// parseFileOptions reads the per-job options of file uploads from form
+// or query values
+func parseFileOptions(job *Job, form url.Values) error {
+	job.PDFEngine = form.Get("pdf_engine")
+	job.Backend = form.Get("backend")
+
+	// Explicit input charset overrides detection
+	if v := form.Get("charset"); v != "" {
+		enc, ok := normalizeCharset(v)
+		if !ok {
+			return errors.New("Unsupported charset")
+		}
+		job.Charset = enc
+	}
+
+	// Spreadsheet options
+	job.Sheet.Sheet = form.Get("sheet")
+	job.Sheet.CellRange = form.Get("range")
+	job.Sheet.HeaderRow = 1
+	if v := form.Get("header_row"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errors.New("Invalid header_row")
+		}
+		job.Sheet.HeaderRow = n
+	}
+	if job.Sheet.CellRange != "" {
+		if _, err := parseCellRange(job.Sheet.CellRange); err != nil {
+			return errors.New("Invalid range")
+		}
+	}
+	return nil
+}
+
 // handleDownload handles file downloads
 func handleDownload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -872,53 +1353,116 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Copy what is served under the lock: processJob and cleanup write
+	// these fields, and expiry shreds the key in place
 	jobStore.RLock()
 	entry, exists := jobStore.jobs[jobID]
+	var status JobStatus
+	var outputPath, name string
+	var key []byte
+	if exists {
+		status, outputPath, name = entry.Status, entry.OutputPath, entry.DownloadName
+		key = append([]byte(nil), entry.Key...)
+	}
 	jobStore.RUnlock()
+	defer shredKey(key)
 
 	if !exists {
 		http.Error(w, "Job not found", http.StatusNotFound)
 		return
 	}
 
-	if entry.Status != StatusDone {
+	// Owners use their token; anyone else needs a signed link
+	q := r.URL.Query()
+	nonce := ""
+	if q.Get("sig") != "" {
+		if !signedLinkValid(q) {
+			http.Error(w, "Invalid or expired link", http.StatusForbidden)
+			return
+		}
+		if nonce = q.Get("once"); nonce != "" && linkUsed(jobID, nonce) {
+			http.Error(w, "Link already used", http.StatusGone)
+			return
+		}
+	} else if !isOwner(entry, r) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	if status != StatusDone {
 		http.Error(w, "Job not complete", http.StatusAccepted)
 		return
 	}
 
-	if entry.OutputPath == "" {
+	if outputPath == "" {
 		http.Error(w, "Output file not available", http.StatusNotFound)
 		return
 	}
 
-	// Read file
-	data, err := os.ReadFile(entry.OutputPath)
-	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+	// A single-use link is spent only by a full GET that completes, so
+	// HEAD and Range requests from previewers and download managers
+	// leave it usable
+	if nonce == "" || r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+		writeOutput(w, r, outputPath, name, key)
+		return
+	}
+	if !consumeLink(jobID, nonce) {
+		http.Error(w, "Link already used", http.StatusGone)
+		return
+	}
+	if !writeOutput(w, r, outputPath, name, key) {
+		releaseLink(jobID, nonce)
+	}
+
+	// File will be cleaned up by the periodic cleanup job (30 minutes)
+}
+
+// handleStatus reports the state of a job
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Determine content type
-	contentType := "application/octet-stream"
-	if strings.HasSuffix(entry.OutputPath, ".html") {
-		contentType = "text/html; charset=utf-8"
-	} else if strings.HasSuffix(entry.OutputPath, ".pdf") {
-		contentType = "application/pdf"
-	} else if strings.HasSuffix(entry.OutputPath, ".json") {
-		contentType = "application/json"
-	} else if strings.HasSuffix(entry.OutputPath, ".txt") {
-		contentType = "text/plain; charset=utf-8"
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		http.Error(w, "Missing job ID", http.StatusBadRequest)
+		return
 	}
 
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(entry.OutputPath))
-	w.Header().Set("Cache-Control", "no-store")
+	jobStore.RLock()
+	entry, exists := jobStore.jobs[jobID]
+	exists = exists && isOwner(entry, r)
+	var resp map[string]interface{}
+	if exists {
+		resp = map[string]interface{}{
+			"job_id":       jobID,
+			"status":       entry.Status,
+			"from":         entry.FromFmt,
+			"to":           entry.ToFmt,
+			"detection":    entry.Detection,
+			"encoding":     entry.Encoding,
+			"pdf_engine":   entry.PDFEngine,
+			"pdf_attempts": entry.PDFAttempts,
+			"warnings":     entry.Warnings,
+			"timings":      entry.Timings,
+			"created_at":   entry.CreatedAt.Format(time.RFC3339),
+		}
+		if entry.Error != "" {
+			resp["error"] = entry.Error
+			resp["error_info"] = entry.ErrorInfo
+		}
+	}
+	jobStore.RUnlock()
 
-	if _, err := w.Write(data); err != nil {
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
 		return
 	}
 
-	// File will be cleaned up by the periodic cleanup job (30 minutes)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
 }
 
 // handleFormats returns supported formats
@@ -931,8 +1475,29 @@ func handleFormats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "public, max-age=3600")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"input":  inputFormats,
-		"output": outputFormats,
+		"input":       inputFormats,
+		"output":      outputFormats,
+		"pdf_engines": availablePDFEngines,
+	})
+}
+
+// handleMetrics reports per-class queue metrics and per-backend conversion timings
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queue_depth":      queueDepth(),
+		"pools":            poolStatsSnapshot(),
+		"memory":           memorySnapshot(),
+		"backends":         backendStatsSnapshot(),
+		"default_backend":  cfg().Backend,
+		"server_available": pandocSrv.available(),
+		"libreoffice":      converterByName(BackendLibreOffice) != nil,
 	})
 }
 
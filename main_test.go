@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCleanupOldJobsKeepsActiveJobs(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.WorkDir = t.TempDir()
+		c.JobRetention = Duration(time.Minute)
+	})
+	old := time.Now().Add(-time.Hour)
+	addTestJob(t, "retention-queued", StatusQueued, old, 1)
+	addTestJob(t, "retention-processing", StatusProcessing, old, 1)
+	addTestJob(t, "retention-done", StatusDone, old, 1)
+	addTestJob(t, "retention-recent", StatusDone, time.Now(), 1)
+
+	cleanupOldJobs()
+
+	jobStore.RLock()
+	defer jobStore.RUnlock()
+	for id, want := range map[string]bool{
+		"retention-queued":     true,
+		"retention-processing": true,
+		"retention-done":       false,
+		"retention-recent":     true,
+	} {
+		if _, ok := jobStore.jobs[id]; ok != want {
+			t.Errorf("%s kept = %v, want %v", id, ok, want)
+		}
+	}
+}
+
+func TestUpdateJobAfterRemoval(t *testing.T) {
+	// A job removed while it runs must not crash the worker updating it
+	updateJob("no-such-job", func(e *JobEntry) { e.Status = StatusDone })
+}
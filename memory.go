@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// memoryAvailable returns the bytes the process can still use; tests
+// replace it to simulate memory pressure
+var memoryAvailable = readMemoryAvailable
+
+// readMemoryAvailable takes the tighter of the container's cgroup limit and
+// the host's MemAvailable. ok is false when neither can be read.
+func readMemoryAvailable() (avail, limit int64, ok bool) {
+	if l, usage, found := cgroupMemory(); found {
+		avail, limit, ok = l-usage, l, true
+	}
+	if hostAvail, hostTotal, found := hostMemory(); found {
+		if !ok || hostAvail < avail {
+			avail = hostAvail
+		}
+		if !ok {
+			limit = hostTotal
+		}
+		ok = true
+	}
+	if avail < 0 {
+		avail = 0
+	}
+	return avail, limit, ok
+}
+
+// cgroupMemory reads the cgroup v2 or v1 memory limit and usage. Inactive
+// file cache is not counted as usage since the kernel reclaims it first.
+func cgroupMemory() (limit, usage int64, ok bool) {
+	paths := []struct{ limit, usage, stat, inactiveKey string }{
+		{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory.current", "/sys/fs/cgroup/memory.stat", "inactive_file"},
+		{"/sys/fs/cgroup/memory/memory.limit_in_bytes", "/sys/fs/cgroup/memory/memory.usage_in_bytes", "/sys/fs/cgroup/memory/memory.stat", "total_inactive_file"},
+	}
+	for _, p := range paths {
+		limit, err := readIntFile(p.limit)
+		if err != nil {
+			continue
+		}
+		// "max" or a huge v1 value means no limit
+		if limit <= 0 || limit >= 1<<62 {
+			return 0, 0, false
+		}
+		usage, err := readIntFile(p.usage)
+		if err != nil {
+			return 0, 0, false
+		}
+		if stats, err := readKeyValues(p.stat, " "); err == nil {
+			usage -= stats[p.inactiveKey]
+		}
+		return limit, usage, true
+	}
+	return 0, 0, false
+}
+
+// hostMemory reads MemAvailable and MemTotal from /proc/meminfo
+func hostMemory() (avail, total int64, ok bool) {
+	info, err := readKeyValues("/proc/meminfo", ":")
+	if err != nil {
+		return 0, 0, false
+	}
+	avail, hasAvail := info["MemAvailable"]
+	total, hasTotal := info["MemTotal"]
+	// Values are in kB
+	return avail * 1024, total * 1024, hasAvail && hasTotal
+}
+
+func readIntFile(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// readKeyValues parses "key<sep> value [unit]" lines into integers
+func readKeyValues(path, sep string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, found := strings.Cut(scanner.Text(), sep)
+		if !found {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			out[strings.TrimSpace(key)] = n
+		}
+	}
+	return out, scanner.Err()
+}
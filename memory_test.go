@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadKeyValues(t *testing.T) {
+	dir := t.TempDir()
+	meminfo := filepath.Join(dir, "meminfo")
+	if err := os.WriteFile(meminfo, []byte("MemTotal:        8000000 kB\nMemAvailable:    2000000 kB\nHugePages_Total:       0\nbroken line\nEmpty:\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readKeyValues(meminfo, ":")
+	want := map[string]int64{"MemTotal": 8000000, "MemAvailable": 2000000, "HugePages_Total": 0}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("meminfo = %v, %v; want %v", got, err, want)
+	}
+
+	stat := filepath.Join(dir, "memory.stat")
+	if err := os.WriteFile(stat, []byte("anon 1048576\ninactive_file 4096\nnot_a_number x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readKeyValues(stat, " ")
+	want = map[string]int64{"anon": 1048576, "inactive_file": 4096}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("memory.stat = %v, %v; want %v", got, err, want)
+	}
+
+	if _, err := readKeyValues(filepath.Join(dir, "missing"), ":"); err == nil {
+		t.Error("missing file read without error")
+	}
+}
+
+func TestReadIntFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]struct {
+		content string
+		want    int64
+		wantErr bool
+	}{
+		"limit":   {"536870912\n", 536870912, false},
+		"max":     {"max\n", 0, false},
+		"garbage": {"lots\n", 0, true},
+	}
+	for name, tt := range tests {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readIntFile(path)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: readIntFile = %d, %v; want %d, error %v", name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
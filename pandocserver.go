@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pandocServer manages a long-lived `pandoc server` process
+type pandocServer struct {
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	baseURL string
+	healthy bool
+	client  *http.Client
+}
+
+// pandocSrv is nil unless the server backend is enabled
+var pandocSrv *pandocServer
+
+// startPandocServer launches the server backend when it is the configured
+// default backend
+func startPandocServer() {
+	if cfg().Backend != BackendServer {
+		return
+	}
+
+	pandocSrv = &pandocServer{client: &http.Client{}}
+	if err := pandocSrv.start(); err != nil {
+		log.Printf("Pandoc server failed to start, falling back to CLI: %v", err)
+	}
+	go pandocSrv.monitor()
+}
+
+// start spawns pandoc server on a free loopback port and waits until it answers
+func (s *pandocServer) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command("pandoc", "server", "--port", strconv.Itoa(port), "--timeout", "60")
+	cmd.Stdout = io.Discard
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	s.cmd = cmd
+	s.exited = exited
+	s.baseURL = "http://127.0.0.1:" + strconv.Itoa(port)
+	s.healthy = false
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-exited:
+			return fmt.Errorf("pandoc server exited during startup")
+		default:
+		}
+		if s.ping() == nil {
+			s.healthy = true
+			log.Printf("Pandoc server listening on %s", s.baseURL)
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("pandoc server did not become ready")
+}
+
+// ping checks the server's version endpoint
+func (s *pandocServer) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/version", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// monitor health-checks the server and restarts it after crashes or hangs
+func (s *pandocServer) monitor() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	failures := 0
+
+	for range ticker.C {
+		s.mu.Lock()
+		exited := s.exited
+		s.mu.Unlock()
+
+		crashed := false
+		if exited != nil {
+			select {
+			case <-exited:
+				crashed = true
+			default:
+			}
+		}
+
+		if !crashed && exited != nil && s.ping() == nil {
+			failures = 0
+			s.setHealthy(true)
+			continue
+		}
+
+		failures++
+		s.setHealthy(false)
+		if !crashed && failures < 3 {
+			continue
+		}
+
+		log.Printf("Restarting pandoc server")
+		s.stop()
+		if err := s.start(); err != nil {
+			log.Printf("Pandoc server restart failed: %v", err)
+		} else {
+			failures = 0
+		}
+	}
+}
+
+func (s *pandocServer) setHealthy(v bool) {
+	s.mu.Lock()
+	s.healthy = v
+	s.mu.Unlock()
+}
+
+// available reports whether the server is up and passing health checks
+func (s *pandocServer) available() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthy
+}
+
+func (s *pandocServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd != nil && s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+		<-s.exited
+	}
+	s.cmd = nil
+	s.exited = nil
+	s.healthy = false
+}
+
+// serverResponse is pandoc server's JSON reply
+type serverResponse struct {
+	Output   string `json:"output"`
+	Base64   bool   `json:"base64"`
+	Messages []struct {
+		Verbosity string `json:"verbosity"`
+		Message   string `json:"message"`
+	} `json:"messages"`
+	Error string `json:"error"`
+}
+
+// convert runs a text-to-text conversion on the server. It returns
+// errBackendUnavailable when the request never reached pandoc, so the
+// next backend can be tried.
+func (s *pandocServer) convert(ctx context.Context, text, from, to string) ([]byte, []Warning, error) {
+	s.mu.Lock()
+	baseURL := s.baseURL
+	s.mu.Unlock()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"text":       text,
+		"from":       from,
+		"to":         to,
+		"standalone": true,
+		"wrap":       "none",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		s.setHealthy(false)
+		return nil, nil, fmt.Errorf("%w: %v", errBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errBackendUnavailable, err)
+	}
+
+	var out serverResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		// Errors are sometimes reported as plain text
+		return nil, nil, fmt.Errorf("pandoc server: %s", strings.TrimSpace(string(data)))
+	}
+	if out.Error != "" || resp.StatusCode != http.StatusOK {
+		msg := out.Error
+		if msg == "" {
+			msg = strings.TrimSpace(string(data))
+		}
+		return nil, nil, fmt.Errorf("pandoc server: %s", msg)
+	}
+
+	var warnings []Warning
+	for _, m := range out.Messages {
+		if m.Verbosity == "WARNING" || m.Verbosity == "ERROR" {
+			warnings = append(warnings, Warning{Type: "PandocServer", Level: strings.ToLower(m.Verbosity), Message: m.Message})
+		}
+	}
+
+	if out.Base64 {
+		decoded, err := base64.StdEncoding.DecodeString(out.Output)
+		return decoded, warnings, err
+	}
+	return []byte(out.Output), warnings, nil
+}
+
+// serverCanConvert reports whether pandoc server handles a format pair;
+// binary inputs and outputs, PDF and native writers stay on the CLI
+func serverCanConvert(fromFmt, toFmt string) bool {
+	if binaryFormats[fromFmt] || binaryFormats[toFmt] {
+		return false
+	}
+	if _, ok := nativeWriters[toFmt]; ok {
+		return false
+	}
+	return true
+}
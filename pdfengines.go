@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Kinds of PDF engine; pandoc renders through a different writer for each
+const (
+	engineLaTeX   = "latex"
+	engineHTML    = "html"
+	engineTypst   = "typst"
+	engineConTeXt = "context"
+)
+
+// pdfEngineKinds lists the PDF engines pandoc can drive, keyed by binary name
+var pdfEngineKinds = map[string]string{
+	"xelatex":     engineLaTeX,
+	"pdflatex":    engineLaTeX,
+	"lualatex":    engineLaTeX,
+	"weasyprint":  engineHTML,
+	"wkhtmltopdf": engineHTML,
+	"typst":       engineTypst,
+	"context":     engineConTeXt,
+}
+
+// defaultPDFEnginePreference is used unless pdf_engines is configured
+var defaultPDFEnginePreference = []string{
+	"xelatex", "pdflatex", "lualatex", "weasyprint", "typst", "wkhtmltopdf", "context",
+}
+
+// availablePDFEngines holds installed engines in preference order
+var availablePDFEngines []string
+
+// defaultPDFCSS gives HTML-rendered PDFs sensible print typography
+const defaultPDFCSS = `@page { size: A4; margin: 2cm; }
+body { font-family: "DejaVu Serif", Georgia, serif; font-size: 11pt; line-height: 1.5; color: #111; }
+h1, h2, h3, h4 { font-family: "DejaVu Sans", Helvetica, sans-serif; line-height: 1.25; page-break-after: avoid; }
+h1 { font-size: 1.8em; } h2 { font-size: 1.4em; } h3 { font-size: 1.15em; }
+pre, code { font-family: "DejaVu Sans Mono", monospace; font-size: 0.9em; }
+pre { background: #f5f5f5; padding: 0.6em; white-space: pre-wrap; page-break-inside: avoid; }
+table { border-collapse: collapse; width: 100%; page-break-inside: avoid; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.5em; text-align: left; }
+img { max-width: 100%; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #444; }
+`
+
+// detectPDFEngines finds installed PDF engines once at startup, in the
+// configured preference order
+func detectPDFEngines() {
+	availablePDFEngines = nil
+	for _, name := range cfg().PDFEngines {
+		if _, err := exec.LookPath(name); err == nil {
+			availablePDFEngines = append(availablePDFEngines, name)
+		}
+	}
+
+	if len(availablePDFEngines) == 0 {
+		log.Printf("No PDF engine found; PDF output is disabled")
+	} else {
+		log.Printf("PDF engines available: %s", strings.Join(availablePDFEngines, ", "))
+	}
+}
+
+// isPDFEngineAvailable reports whether an engine was detected at startup
+func isPDFEngineAvailable(name string) bool {
+	for _, e := range availablePDFEngines {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pdfEngineArgs returns the pandoc arguments needed to render with engine
+func pdfEngineArgs(engine string) []string {
+	args := []string{"--pdf-engine=" + engine}
+	if pdfEngineKinds[engine] == engineHTML {
+		// The stylesheet is inlined so engines never need local file access
+		args = append(args, "--variable=header-includes:<style>\n"+defaultPDFCSS+"</style>")
+		if engine == "wkhtmltopdf" {
+			// wkhtmltopdf ignores proxy variables in the environment
+			args = append(args, "--pdf-engine-opt=--proxy", "--pdf-engine-opt="+deadProxy)
+		}
+	}
+	return args
+}
+
+// PDFAttempt records one try at building a PDF with a given engine
+type PDFAttempt struct {
+	Engine     string `json:"engine"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// pdfFallbackOrder ranks engines to try after a failure: Unicode-capable
+// LaTeX engines first, then HTML, typst and ConTeXt engines
+var pdfFallbackOrder = []string{
+	"xelatex", "lualatex", "pdflatex", "weasyprint", "wkhtmltopdf", "typst", "context",
+}
+
+// pdfEngineChain returns the engines to try, starting with first and
+// followed by the other installed engines in fallback order
+func pdfEngineChain(first string) []string {
+	chain := []string{first}
+	for _, name := range pdfFallbackOrder {
+		if name != first && isPDFEngineAvailable(name) {
+			chain = append(chain, name)
+		}
+	}
+	return chain
+}
+
+// Pandoc exit codes for PDF build failures
+const (
+	pandocExitPDFError          = 43
+	pandocExitPDFProgramMissing = 47
+)
+
+// pdfFailureMarkers are stderr fragments of engine errors worth retrying
+var pdfFailureMarkers = []string{
+	"Error producing PDF",
+	"! LaTeX Error",
+	"! Package inputenc Error",
+	"! Package fontspec Error",
+	"Unicode character",
+	"Missing character",
+	".sty' not found",
+}
+
+// isRetryablePDFFailure reports whether a failed pandoc run looks like an
+// engine-specific PDF build error that another engine may not hit
+func isRetryablePDFFailure(exitCode int, stderr string) bool {
+	if exitCode == pandocExitPDFError || exitCode == pandocExitPDFProgramMissing {
+		return true
+	}
+	for _, marker := range pdfFailureMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
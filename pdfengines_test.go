@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withPDFEngines detects engines as if only installed were on PATH, under
+// the configured preference order
+func withPDFEngines(t *testing.T, preference, installed []string) {
+	t.Helper()
+	bin := t.TempDir()
+	for _, name := range installed {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin)
+	withConfig(t, func(c *Config) { c.PDFEngines = preference })
+	old := availablePDFEngines
+	t.Cleanup(func() { availablePDFEngines = old })
+	detectPDFEngines()
+}
+
+func TestPDFEngineChain(t *testing.T) {
+	all := []string{"xelatex", "pdflatex", "lualatex", "weasyprint", "typst", "wkhtmltopdf", "context"}
+	tests := []struct {
+		name       string
+		preference []string
+		installed  []string
+		available  []string // detected, in preference order
+		first      string
+		chain      []string
+	}{
+		{
+			name:       "default preference",
+			preference: defaultPDFEnginePreference,
+			installed:  all,
+			available:  defaultPDFEnginePreference,
+			first:      "xelatex",
+			chain:      []string{"xelatex", "lualatex", "pdflatex", "weasyprint", "wkhtmltopdf", "typst", "context"},
+		},
+		{
+			name:       "configured preference picks the first engine",
+			preference: []string{"pdflatex", "typst", "weasyprint"},
+			installed:  all,
+			available:  []string{"pdflatex", "typst", "weasyprint"},
+			first:      "pdflatex",
+			chain:      []string{"pdflatex", "weasyprint", "typst"},
+		},
+		{
+			name:       "engines that are not installed are skipped",
+			preference: []string{"weasyprint", "xelatex", "context"},
+			installed:  []string{"context", "weasyprint"},
+			available:  []string{"weasyprint", "context"},
+			first:      "weasyprint",
+			chain:      []string{"weasyprint", "context"},
+		},
+		{
+			name:       "a requested engine leads the chain",
+			preference: defaultPDFEnginePreference,
+			installed:  []string{"xelatex", "typst", "wkhtmltopdf"},
+			available:  []string{"xelatex", "typst", "wkhtmltopdf"},
+			first:      "typst",
+			chain:      []string{"typst", "xelatex", "wkhtmltopdf"},
+		},
+		{
+			name:       "single engine",
+			preference: []string{"typst"},
+			installed:  all,
+			available:  []string{"typst"},
+			first:      "typst",
+			chain:      []string{"typst"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withPDFEngines(t, tt.preference, tt.installed)
+			if !reflect.DeepEqual(availablePDFEngines, tt.available) {
+				t.Errorf("available = %v, want %v", availablePDFEngines, tt.available)
+			}
+			if got := pdfEngineChain(tt.first); !reflect.DeepEqual(got, tt.chain) {
+				t.Errorf("pdfEngineChain(%s) = %v, want %v", tt.first, got, tt.chain)
+			}
+		})
+	}
+}
+
+func TestIsRetryablePDFFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		stderr   string
+		want     bool
+	}{
+		{"pandoc PDF error exit", pandocExitPDFError, "", true},
+		{"pandoc PDF program missing exit", pandocExitPDFProgramMissing, "", true},
+		{"error producing PDF", 1, "Error producing PDF.\n! Undefined control sequence.", true},
+		{"LaTeX error", 1, "! LaTeX Error: File `foo.sty' not found.", true},
+		{"inputenc error", 1, "! Package inputenc Error: Unicode character ✓ (U+2713)", true},
+		{"fontspec error", 1, "! Package fontspec Error: The font \"X\" cannot be found.", true},
+		{"missing glyph", 0, "[WARNING] Missing character: There is no 😀 in font lmroman10", true},
+		{"missing package", 1, "kpathsea: File `emoji.sty' not found.", true},
+		{"unknown reader", 21, "Unknown input format foo", false},
+		{"input parse error", 64, "YAML parse exception at line 3", false},
+		{"killed", -1, "signal: killed", false},
+		{"clean exit", 0, "", false},
+		{"marker case differs", 1, "error producing pdf", false},
+	}
+	for _, tt := range tests {
+		if got := isRetryablePDFFailure(tt.exitCode, tt.stderr); got != tt.want {
+			t.Errorf("%s: isRetryablePDFFailure(%d, %q) = %v, want %v", tt.name, tt.exitCode, tt.stderr, got, tt.want)
+		}
+	}
+}
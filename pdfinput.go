@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// bboxDoc mirrors the XHTML written by `pdftotext -bbox-layout`
+type bboxDoc struct {
+	Pages []struct {
+		Flows []struct {
+			Blocks []struct {
+				Lines []bboxLine `xml:"line"`
+			} `xml:"block"`
+		} `xml:"flow"`
+	} `xml:"body>doc>page"`
+}
+
+type bboxLine struct {
+	XMin  float64    `xml:"xMin,attr"`
+	YMin  float64    `xml:"yMin,attr"`
+	XMax  float64    `xml:"xMax,attr"`
+	YMax  float64    `xml:"yMax,attr"`
+	Words []bboxWord `xml:"word"`
+}
+
+type bboxWord struct {
+	XMin float64 `xml:"xMin,attr"`
+	XMax float64 `xml:"xMax,attr"`
+	Text string  `xml:",chardata"`
+}
+
+// pdfLine is a line of text with its position on the page
+type pdfLine struct {
+	block  int
+	x, y   float64
+	height float64
+	cells  []string // words grouped by wide horizontal gaps
+	table  int      // index of the detected table on its page, or -1
+}
+
+func (l *pdfLine) text() string {
+	return strings.Join(l.cells, " ")
+}
+
+// extractPDF converts a PDF into an HTML document that approximates its
+// structure: headings by font size, paragraphs by text block and simple
+// tables by column alignment. The result is written to outPath.
+func extractPDF(ctx context.Context, workDir, pdfPath, outPath string) error {
+	if _, err := exec.LookPath("pdftotext"); err != nil {
+		return newConversionError(ErrCodeEngineMissing, "PDF input requires pdftotext to be installed. Please install the poppler-utils package")
+	}
+
+	cmd := sandboxCommand(ctx, workDir, "pdftotext", "-bbox-layout", "-enc", "UTF-8", pdfPath, "-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pdftotext failed: %w, stderr: %s", err, stderr.String())
+	}
+
+	out, err := bboxToHTML(stdout.Bytes())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, []byte(out), 0600)
+}
+
+// bboxToHTML rebuilds document structure from pdftotext bounding boxes
+func bboxToHTML(data []byte) (string, error) {
+	var doc bboxDoc
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+	if err := dec.Decode(&doc); err != nil {
+		return "", fmt.Errorf("failed to parse pdftotext output: %w", err)
+	}
+
+	var pages [][]*pdfLine
+	for _, page := range doc.Pages {
+		var lines []*pdfLine
+		block := 0
+		for _, flow := range page.Flows {
+			for _, b := range flow.Blocks {
+				for _, l := range b.Lines {
+					if pl := newPDFLine(l, block); pl != nil {
+						lines = append(lines, pl)
+					}
+				}
+				block++
+			}
+		}
+		pages = append(pages, lines)
+	}
+
+	body := bodyTextHeight(pages)
+
+	var out strings.Builder
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"></head>\n<body>\n")
+	for _, lines := range pages {
+		writePDFPage(&out, lines, body)
+	}
+	out.WriteString("</body>\n</html>\n")
+
+	if strings.TrimSpace(stripTags(out.String())) == "" {
+		return "", fmt.Errorf("no extractable text found in PDF (scanned documents are not supported)")
+	}
+	return out.String(), nil
+}
+
+// newPDFLine groups a line's words into cells separated by wide gaps
+func newPDFLine(l bboxLine, block int) *pdfLine {
+	if len(l.Words) == 0 {
+		return nil
+	}
+	height := l.YMax - l.YMin
+	pl := &pdfLine{block: block, x: l.XMin, y: l.YMin, height: height, table: -1}
+
+	var cell []string
+	for i, w := range l.Words {
+		if i > 0 && w.XMin-l.Words[i-1].XMax > 1.5*height {
+			pl.cells = append(pl.cells, strings.Join(cell, " "))
+			cell = nil
+		}
+		cell = append(cell, strings.TrimSpace(w.Text))
+	}
+	pl.cells = append(pl.cells, strings.Join(cell, " "))
+	return pl
+}
+
+// bodyTextHeight returns the most common line height, weighted by length
+func bodyTextHeight(pages [][]*pdfLine) float64 {
+	weights := make(map[float64]int)
+	for _, lines := range pages {
+		for _, l := range lines {
+			weights[math.Round(l.height*2)/2] += len(l.text())
+		}
+	}
+	best, bestWeight := 0.0, -1
+	for h, w := range weights {
+		if w > bestWeight || (w == bestWeight && h < best) {
+			best, bestWeight = h, w
+		}
+	}
+	return best
+}
+
+// pdfRow is a set of lines sharing a baseline
+type pdfRow struct {
+	y     float64
+	cells []string
+	lines []*pdfLine
+}
+
+// detectPDFTables marks runs of aligned rows as tables and returns them
+func detectPDFTables(lines []*pdfLine) [][]pdfRow {
+	sorted := make([]*pdfLine, len(lines))
+	copy(sorted, lines)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].y < sorted[j].y })
+
+	var rows []pdfRow
+	for _, l := range sorted {
+		if n := len(rows); n > 0 && math.Abs(rows[n-1].y-l.y) < l.height/2 {
+			rows[n-1].lines = append(rows[n-1].lines, l)
+			continue
+		}
+		rows = append(rows, pdfRow{y: l.y, lines: []*pdfLine{l}})
+	}
+	for i := range rows {
+		sort.Slice(rows[i].lines, func(a, b int) bool { return rows[i].lines[a].x < rows[i].lines[b].x })
+		for _, l := range rows[i].lines {
+			rows[i].cells = append(rows[i].cells, l.cells...)
+		}
+	}
+
+	var tables [][]pdfRow
+	for i := 0; i < len(rows); {
+		j := i
+		for j < len(rows) && isTableRow(rows[j]) && len(rows[j].cells) == len(rows[i].cells) {
+			j++
+		}
+		if j-i >= 2 {
+			for _, row := range rows[i:j] {
+				for _, l := range row.lines {
+					l.table = len(tables)
+				}
+			}
+			tables = append(tables, rows[i:j])
+			i = j
+			continue
+		}
+		i++
+	}
+	return tables
+}
+
+// isTableRow reports whether a row looks like tabular data rather than
+// running text (multi-column page layouts have long cells)
+func isTableRow(row pdfRow) bool {
+	if len(row.cells) < 2 {
+		return false
+	}
+	total := 0
+	for _, c := range row.cells {
+		total += len([]rune(c))
+	}
+	return total/len(row.cells) <= 40
+}
+
+// writePDFPage emits one page of headings, paragraphs and tables as HTML
+func writePDFPage(out *strings.Builder, lines []*pdfLine, body float64) {
+	tables := detectPDFTables(lines)
+	emitted := make(map[int]bool)
+
+	var para []*pdfLine
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		writePDFBlock(out, para, body)
+		para = nil
+	}
+
+	for _, l := range lines {
+		if l.table >= 0 {
+			flush()
+			if !emitted[l.table] {
+				writePDFTable(out, tables[l.table])
+				emitted[l.table] = true
+			}
+			continue
+		}
+		if len(para) > 0 && para[len(para)-1].block != l.block {
+			flush()
+		}
+		para = append(para, l)
+	}
+	flush()
+}
+
+// writePDFBlock emits a text block as a heading or a paragraph
+func writePDFBlock(out *strings.Builder, lines []*pdfLine, body float64) {
+	var text strings.Builder
+	height := 0.0
+	for i, l := range lines {
+		t := l.text()
+		height += l.height
+		if i > 0 {
+			prev := text.String()
+			// Rejoin words hyphenated across line breaks
+			if strings.HasSuffix(prev, "-") && t != "" && strings.ToLower(t[:1]) == t[:1] {
+				text.Reset()
+				text.WriteString(strings.TrimSuffix(prev, "-"))
+			} else {
+				text.WriteString(" ")
+			}
+		}
+		text.WriteString(t)
+	}
+	height /= float64(len(lines))
+	content := html.EscapeString(strings.TrimSpace(text.String()))
+	if content == "" {
+		return
+	}
+
+	if body > 0 && len(lines) <= 3 && len(content) < 200 {
+		ratio := height / body
+		switch {
+		case ratio >= 1.8:
+			fmt.Fprintf(out, "<h1>%s</h1>\n", content)
+			return
+		case ratio >= 1.4:
+			fmt.Fprintf(out, "<h2>%s</h2>\n", content)
+			return
+		case ratio >= 1.15:
+			fmt.Fprintf(out, "<h3>%s</h3>\n", content)
+			return
+		}
+	}
+	fmt.Fprintf(out, "<p>%s</p>\n", content)
+}
+
+// writePDFTable emits detected rows as an HTML table with a header row
+func writePDFTable(out *strings.Builder, rows []pdfRow) {
+	out.WriteString("<table>\n")
+	for i, row := range rows {
+		tag := "td"
+		if i == 0 {
+			tag = "th"
+		}
+		out.WriteString("<tr>")
+		for _, c := range row.cells {
+			fmt.Fprintf(out, "<%s>%s</%s>", tag, html.EscapeString(c), tag)
+		}
+		out.WriteString("</tr>\n")
+	}
+	out.WriteString("</table>\n")
+}
+
+// stripTags removes markup, leaving only text content
+func stripTags(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
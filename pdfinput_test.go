@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"testing"
+)
+
+// testBBoxLine is a line at y with the given height. Words within a cell
+// sit close together; cells are separated by wide gaps.
+type testBBoxLine struct {
+	y, height float64
+	cells     [][]string
+}
+
+// bboxXML renders pages of blocks in the pdftotext -bbox-layout format
+func bboxXML(pages ...[][]testBBoxLine) []byte {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html xmlns="http://www.w3.org/1999/xhtml"><head><title></title></head><body><doc>`)
+	for _, blocks := range pages {
+		b.WriteString(`<page width="612" height="792"><flow>`)
+		for _, lines := range blocks {
+			b.WriteString("<block>")
+			for _, l := range lines {
+				var words strings.Builder
+				x := 50.0
+				for i, cell := range l.cells {
+					if i > 0 {
+						x += 100
+					}
+					for j, w := range cell {
+						if j > 0 {
+							x += 2
+						}
+						fmt.Fprintf(&words, `<word xMin="%g" yMin="%g" xMax="%g" yMax="%g">%s</word>`,
+							x, l.y, x+30, l.y+l.height, html.EscapeString(w))
+						x += 30
+					}
+				}
+				fmt.Fprintf(&b, `<line xMin="50" yMin="%g" xMax="%g" yMax="%g">%s</line>`, l.y, x, l.y+l.height, words.String())
+			}
+			b.WriteString("</block>")
+		}
+		b.WriteString("</flow></page>")
+	}
+	b.WriteString("</doc></body></html>")
+	return []byte(b.String())
+}
+
+// words splits s into a single-cell line
+func words(y, height float64, s string) testBBoxLine {
+	return testBBoxLine{y: y, height: height, cells: [][]string{strings.Fields(s)}}
+}
+
+func TestBBoxToHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages [][][]testBBoxLine
+		want  []string
+	}{
+		{
+			name: "lines of a block form one paragraph",
+			pages: [][][]testBBoxLine{{
+				{words(100, 10, "The first line"), words(112, 10, "continues here.")},
+			}},
+			want: []string{"<p>The first line continues here.</p>"},
+		},
+		{
+			name: "hyphenated words are rejoined",
+			pages: [][][]testBBoxLine{{
+				{words(100, 10, "a conver-"), words(112, 10, "sion tool")},
+			}},
+			want: []string{"<p>a conversion tool</p>"},
+		},
+		{
+			name: "blocks become separate paragraphs",
+			pages: [][][]testBBoxLine{{
+				{words(100, 10, "First paragraph.")},
+				{words(130, 10, "Second paragraph.")},
+			}},
+			want: []string{"<p>First paragraph.</p>\n<p>Second paragraph.</p>"},
+		},
+		{
+			name: "pages do not join paragraphs",
+			pages: [][][]testBBoxLine{
+				{{words(700, 10, "End of page")}},
+				{{words(50, 10, "start of next")}},
+			},
+			want: []string{"<p>End of page</p>\n<p>start of next</p>"},
+		},
+		{
+			name: "large text becomes headings",
+			pages: [][][]testBBoxLine{{
+				{words(50, 20, "Title")},
+				{words(80, 15, "Section")},
+				{words(100, 10, "Body text that is long enough to set the body height.")},
+			}},
+			want: []string{"<h1>Title</h1>", "<h2>Section</h2>", "<p>Body text"},
+		},
+		{
+			name: "text is escaped",
+			pages: [][][]testBBoxLine{{
+				{words(100, 10, `<script> & "quotes"`)},
+			}},
+			want: []string{"<p>&lt;script&gt; &amp; &#34;quotes&#34;</p>"},
+		},
+		{
+			name: "aligned rows become a table",
+			pages: [][][]testBBoxLine{{
+				{{y: 100, height: 10, cells: [][]string{{"Name"}, {"Size"}}}},
+				{{y: 112, height: 10, cells: [][]string{{"a<b"}, {"1"}}}},
+				{words(150, 10, "After the table.")},
+			}},
+			want: []string{
+				"<table>\n<tr><th>Name</th><th>Size</th></tr>\n<tr><td>a&lt;b</td><td>1</td></tr>\n</table>",
+				"<p>After the table.</p>",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bboxToHTML(bboxXML(tt.pages...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("output lacks %q:\n%s", want, got)
+				}
+			}
+			if strings.Contains(got, "<script>") {
+				t.Errorf("unescaped markup in output:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestBBoxToHTMLWithoutText(t *testing.T) {
+	if _, err := bboxToHTML(bboxXML([][]testBBoxLine{{}})); err == nil {
+		t.Error("a PDF without text was accepted")
+	}
+	if _, err := bboxToHTML([]byte("not xml <")); err == nil {
+		t.Error("malformed pdftotext output was accepted")
+	}
+}
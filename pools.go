@@ -0,0 +1,325 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job cost classes, each served by its own worker pool
+const (
+	ClassLight  = "light"  // text conversions that finish in milliseconds
+	ClassPDF    = "pdf"    // PDF builds through TeX, HTML or typst engines
+	ClassOffice = "office" // LibreOffice conversions
+)
+
+// workerPool runs jobs of one cost class from a bounded queue, with the
+// worker count scaled between the configured min and max by the autoscaler
+type workerPool struct {
+	class string
+	queue chan Job
+	quit  chan struct{} // each receive stops one worker
+
+	workers   atomic.Int64
+	busy      atomic.Int64
+	processed atomic.Int64
+	waitMs    atomic.Int64
+	rejected  atomic.Int64
+	shed      atomic.Int64
+
+	mu        sync.Mutex
+	avgJobMs  float64 // moving average of job duration
+	idleTicks int
+}
+
+// pools holds the worker pool of each class
+var pools = map[string]*workerPool{}
+
+// Autoscaler settings
+const (
+	scaleInterval  = 2 * time.Second
+	scaleDownAfter = 5               // idle intervals before a worker is stopped
+	drainTarget    = 5 * time.Second // queue drain time the scaler aims for
+)
+
+// initialJobMs seeds each class's average job duration before any job ran
+var initialJobMs = map[string]float64{ClassLight: 200, ClassPDF: 10000, ClassOffice: 5000}
+
+// defaultMaxWorkers scales a class's default maximum with the CPU count
+func defaultMaxWorkers(class string) int {
+	switch class {
+	case ClassPDF:
+		return max(1, runtime.NumCPU()/2)
+	case ClassOffice:
+		return max(1, runtime.NumCPU()/4)
+	}
+	return max(4, 2*runtime.NumCPU())
+}
+
+// startWorkers creates the pools at their minimum size and starts the
+// autoscaler
+func startWorkers() {
+	for _, class := range []string{ClassLight, ClassPDF, ClassOffice} {
+		c := cfg().Pools.get(class)
+		p := &workerPool{
+			class:    class,
+			queue:    make(chan Job, c.Queue),
+			quit:     make(chan struct{}, 1024),
+			avgJobMs: initialJobMs[class],
+		}
+		pools[class] = p
+
+		for i := 0; i < c.MinWorkers; i++ {
+			p.startWorker()
+		}
+		log.Printf("Pool %s: %d-%d workers, queue %d, timeout %s", class, c.MinWorkers, c.MaxWorkers, c.Queue, c.Timeout.Std())
+	}
+
+	go autoscale()
+}
+
+// settings returns the pool's current configuration
+func (p *workerPool) settings() *PoolConfig {
+	return cfg().Pools.get(p.class)
+}
+
+// timeout is how long a job of this class may run
+func (p *workerPool) timeout() time.Duration {
+	return p.settings().Timeout.Std()
+}
+
+func (p *workerPool) startWorker() {
+	p.workers.Add(1)
+	go p.work()
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case <-p.quit:
+			p.workers.Add(-1)
+			return
+		case job := <-p.queue:
+			p.busy.Add(1)
+			p.waitMs.Add(time.Since(job.EnqueuedAt).Milliseconds())
+			start := time.Now()
+			reserved := p.reserveMemory()
+			processJob(job, p.class, p.timeout())
+			releaseMemory(reserved)
+			p.observe(time.Since(start))
+			p.processed.Add(1)
+			p.busy.Add(-1)
+		}
+	}
+}
+
+// observe folds a job duration into the moving average
+func (p *workerPool) observe(d time.Duration) {
+	p.mu.Lock()
+	p.avgJobMs = 0.8*p.avgJobMs + 0.2*float64(d.Milliseconds())
+	p.mu.Unlock()
+}
+
+func (p *workerPool) averageJobMs() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.avgJobMs
+}
+
+// enqueue adds a job without blocking, reporting false when the queue is full
+func (p *workerPool) enqueue(job Job) bool {
+	select {
+	case p.queue <- job:
+		return true
+	default:
+		p.rejected.Add(1)
+		return false
+	}
+}
+
+// memoryReserved is the estimated peak memory of running jobs. A job's
+// usage grows while it runs, so it counts at its estimate until it ends.
+var memoryReserved atomic.Int64
+
+// reserveMemory counts a starting job's estimated memory and returns the
+// amount to pass to releaseMemory when it ends
+func (p *workerPool) reserveMemory() int64 {
+	n := p.jobMemory()
+	memoryReserved.Add(n)
+	return n
+}
+
+func releaseMemory(n int64) {
+	memoryReserved.Add(-n)
+}
+
+// memoryFree is the available memory not yet claimed by running jobs
+func memoryFree() (int64, bool) {
+	avail, _, ok := memoryAvailable()
+	return avail - memoryReserved.Load(), ok
+}
+
+// memoryPressure reports whether another job of this class would push the
+// server past its memory reserve
+func (p *workerPool) memoryPressure() bool {
+	free, ok := memoryFree()
+	return ok && free < memoryReserve()+p.jobMemory()
+}
+
+// jobMemory is the estimated peak memory of one job, in bytes
+func (p *workerPool) jobMemory() int64 {
+	return int64(p.settings().JobMemoryMB) << 20
+}
+
+// memoryReserve is kept free for the server itself
+func memoryReserve() int64 {
+	return int64(cfg().MemoryReserveMB) << 20
+}
+
+// retryAfter estimates in seconds when the pool will have room again
+func (p *workerPool) retryAfter() int {
+	workers := max(p.workers.Load(), 1)
+	secs := int(p.averageJobMs()*float64(int64(len(p.queue))+workers)/float64(workers)/1000) + 1
+	return min(max(secs, 1), 60)
+}
+
+// autoscale periodically resizes every pool
+func autoscale() {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, p := range pools {
+			p.scale()
+		}
+	}
+}
+
+// scale adds workers when the queue would take longer than drainTarget to
+// empty and memory allows, and stops a worker after a run of idle intervals
+func (p *workerPool) scale() {
+	queued, workers := int64(len(p.queue)), p.workers.Load()
+	delta := p.scaleDelta(queued, workers, p.busy.Load())
+	for i := int64(0); i < delta; i++ {
+		p.startWorker()
+	}
+	switch {
+	case delta > 0 && queued > 0:
+		log.Printf("Pool %s: scaled up to %d workers (%d queued)", p.class, workers+delta, queued)
+	case delta < 0:
+		p.quit <- struct{}{}
+		log.Printf("Pool %s: scaled down to %d workers", p.class, workers-1)
+	}
+}
+
+// scaleDelta returns how many workers to start, or -1 to stop one, given
+// the queue length and the running and busy worker counts
+func (p *workerPool) scaleDelta(queued, workers, busy int64) int64 {
+	settings := p.settings()
+	minWorkers, maxWorkers := int64(settings.MinWorkers), int64(settings.MaxWorkers)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if queued > 0 {
+		p.idleTicks = 0
+		drainMs := float64(queued) * p.avgJobMs / float64(max(workers, 1))
+		if workers >= maxWorkers || drainMs < float64(drainTarget.Milliseconds()) {
+			return 0
+		}
+
+		// Enough workers to meet the drain target, bounded by free memory
+		want := int64(float64(queued)*p.avgJobMs/float64(drainTarget.Milliseconds())) + 1
+		add := min(want-workers, maxWorkers-workers, queued)
+		if free, ok := memoryFree(); ok {
+			add = min(add, (free-memoryReserve())/p.jobMemory())
+		}
+		return max(add, 0)
+	}
+
+	// Below the minimum after a reload lowered and raised it again
+	if workers < minWorkers {
+		return minWorkers - workers
+	}
+
+	if busy < workers && workers > minWorkers {
+		p.idleTicks++
+		if p.idleTicks >= scaleDownAfter {
+			p.idleTicks = 0
+			return -1
+		}
+		return 0
+	}
+	p.idleTicks = 0
+	return 0
+}
+
+// largeInputBytes is the input size from which a text conversion is no
+// longer light
+const largeInputBytes = 8 << 20
+
+// classifyJob picks the cost class of a job from its formats, backend and
+// input size. Large text conversions run in the PDF pool, whose timeout and
+// per-job memory estimate fit them better than the light pool's.
+func classifyJob(job Job) string {
+	backend := preferredBackend(job.Backend, job.FromFmt, job.ToFmt)
+	if c := converterByName(backend); c != nil && backend == BackendLibreOffice && c.CanConvert(job.FromFmt, job.ToFmt) {
+		return ClassOffice
+	}
+	if job.ToFmt == "pdf" || job.Size >= largeInputBytes {
+		return ClassPDF
+	}
+	return ClassLight
+}
+
+// queueDepth is the number of jobs waiting across all pools
+func queueDepth() int {
+	n := 0
+	for _, p := range pools {
+		n += len(p.queue)
+	}
+	return n
+}
+
+// poolStatsSnapshot reports queue and worker metrics per class
+func poolStatsSnapshot() map[string]interface{} {
+	out := make(map[string]interface{})
+	for class, p := range pools {
+		processed := p.processed.Load()
+		var avgWait int64
+		if processed > 0 {
+			avgWait = p.waitMs.Load() / processed
+		}
+		settings := p.settings()
+		out[class] = map[string]interface{}{
+			"queue_depth":    len(p.queue),
+			"queue_capacity": cap(p.queue),
+			"workers":        p.workers.Load(),
+			"min_workers":    settings.MinWorkers,
+			"max_workers":    settings.MaxWorkers,
+			"busy":           p.busy.Load(),
+			"processed":      processed,
+			"rejected":       p.rejected.Load(),
+			"shed":           p.shed.Load(),
+			"avg_wait_ms":    avgWait,
+			"avg_job_ms":     int64(p.averageJobMs()),
+			"timeout_s":      int(p.timeout() / time.Second),
+		}
+	}
+	return out
+}
+
+// memorySnapshot reports the memory figures the autoscaler works from
+func memorySnapshot() map[string]interface{} {
+	avail, limit, ok := memoryAvailable()
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{
+		"available_bytes": avail,
+		"limit_bytes":     limit,
+		"reserve_bytes":   memoryReserve(),
+		"reserved_bytes":  memoryReserved.Load(),
+	}
+}
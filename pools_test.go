@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+)
+
+// withMemory makes memoryAvailable report avail bytes, or nothing when
+// avail is negative
+func withMemory(t *testing.T, avail int64) {
+	t.Helper()
+	old := memoryAvailable
+	memoryAvailable = func() (int64, int64, bool) { return avail, 8 << 30, avail >= 0 }
+	t.Cleanup(func() { memoryAvailable = old })
+}
+
+// testPool returns a light pool of 1-4 workers whose jobs take a second
+// and need 32 MB, with 64 MB kept in reserve
+func testPool(t *testing.T) *workerPool {
+	t.Helper()
+	withConfig(t, func(c *Config) {
+		c.MemoryReserveMB = 64
+		c.Pools.Light = PoolConfig{MinWorkers: 1, MaxWorkers: 4, Queue: 100, JobMemoryMB: 32}
+	})
+	return &workerPool{class: ClassLight, avgJobMs: 1000}
+}
+
+func TestScaleUp(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name            string
+		avail           int64 // -1 when unknown
+		queued, workers int64
+		want            int64
+	}{
+		{"queue drains within the target", 1 << 30, 4, 1, 0},
+		{"queue at the drain target", 1 << 30, 5, 1, 1},
+		{"enough workers for the target", 1 << 30, 10, 1, 2},
+		{"bounded by max workers", 1 << 30, 100, 1, 3},
+		{"already at max workers", 1 << 30, 100, 4, 0},
+		{"bounded by free memory", 64*mb + 32*mb, 100, 1, 1},
+		{"memory below the reserve", 32 * mb, 100, 1, 0},
+		{"unknown memory does not block", -1, 100, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPool(t)
+			withMemory(t, tt.avail)
+			if got := p.scaleDelta(tt.queued, tt.workers, tt.workers); got != tt.want {
+				t.Errorf("scaleDelta(%d queued, %d workers) = %d, want %d", tt.queued, tt.workers, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScaleDown(t *testing.T) {
+	p := testPool(t)
+	withMemory(t, 1<<30)
+
+	// An idle worker above the minimum is stopped after scaleDownAfter ticks
+	for i := 1; i < scaleDownAfter; i++ {
+		if got := p.scaleDelta(0, 3, 1); got != 0 {
+			t.Fatalf("tick %d: scaleDelta = %d, want 0", i, got)
+		}
+	}
+	if got := p.scaleDelta(0, 3, 1); got != -1 {
+		t.Fatalf("tick %d: scaleDelta = %d, want -1", scaleDownAfter, got)
+	}
+
+	// Fully busy pools and queued jobs restart the count
+	for _, busy := range []bool{true, false} {
+		for i := 1; i < scaleDownAfter; i++ {
+			p.scaleDelta(0, 3, 1)
+		}
+		if busy {
+			p.scaleDelta(0, 3, 3)
+		} else {
+			p.scaleDelta(1, 3, 3)
+		}
+		if got := p.scaleDelta(0, 3, 1); got != 0 {
+			t.Errorf("busy=%v: scaleDelta = %d after the idle run was interrupted, want 0", busy, got)
+		}
+	}
+
+	// The minimum is kept, and restored after a reload raises it
+	for i := 0; i < 2*scaleDownAfter; i++ {
+		if got := p.scaleDelta(0, 1, 0); got != 0 {
+			t.Fatalf("scaleDelta at min workers = %d, want 0", got)
+		}
+	}
+	withConfig(t, func(c *Config) { c.Pools.Light.MinWorkers = 3 })
+	if got := p.scaleDelta(0, 1, 0); got != 2 {
+		t.Errorf("scaleDelta below min workers = %d, want 2", got)
+	}
+}
+
+func TestClassifyJob(t *testing.T) {
+	saved := converters
+	cli := pandocCLIConverter{}
+	lo := &libreOfficeConverter{binary: "soffice", slots: make(chan struct{}, 1)}
+	t.Cleanup(func() { converters = saved })
+
+	tests := []struct {
+		name      string
+		officeOn  bool
+		job       Job
+		wantClass string
+	}{
+		{"small text", true, Job{FromFmt: "markdown", ToFmt: "html", Size: 1 << 10}, ClassLight},
+		{"text just below the large size", true, Job{FromFmt: "markdown", ToFmt: "docx", Size: largeInputBytes - 1}, ClassLight},
+		{"large text", true, Job{FromFmt: "markdown", ToFmt: "html", Size: largeInputBytes}, ClassPDF},
+		{"PDF output", true, Job{FromFmt: "markdown", ToFmt: "pdf", Size: 1 << 10}, ClassPDF},
+		{"office pair LibreOffice prefers", true, Job{FromFmt: "docx", ToFmt: "odt"}, ClassOffice},
+		{"office pair without LibreOffice", false, Job{FromFmt: "docx", ToFmt: "odt"}, ClassLight},
+		{"office to PDF through pandoc", true, Job{FromFmt: "docx", ToFmt: "pdf"}, ClassPDF},
+		{"office to PDF through LibreOffice", true, Job{FromFmt: "docx", ToFmt: "pdf", Backend: BackendLibreOffice}, ClassOffice},
+		{"large office input", true, Job{FromFmt: "docx", ToFmt: "odt", Size: largeInputBytes}, ClassOffice},
+		{"pandoc requested for an office pair", true, Job{FromFmt: "docx", ToFmt: "odt", Backend: BackendCLI}, ClassLight},
+	}
+	for _, tt := range tests {
+		converters = []Converter{cli}
+		if tt.officeOn {
+			converters = append(converters, lo)
+		}
+		if got := classifyJob(tt.job); got != tt.wantClass {
+			t.Errorf("%s: classifyJob = %s, want %s", tt.name, got, tt.wantClass)
+		}
+	}
+}
+
+func TestMemoryReservation(t *testing.T) {
+	const mb = 1 << 20
+	p := testPool(t)
+	// Room for the reserve and two jobs
+	withMemory(t, 64*mb+2*32*mb)
+
+	if p.memoryPressure() {
+		t.Fatal("memory pressure with nothing running")
+	}
+	first := p.reserveMemory()
+	if p.memoryPressure() {
+		t.Error("memory pressure with room for one more job")
+	}
+	if got := p.scaleDelta(100, 1, 1); got != 1 {
+		t.Errorf("scaleDelta with room for one job = %d, want 1", got)
+	}
+	second := p.reserveMemory()
+	if !p.memoryPressure() {
+		t.Error("no memory pressure once running jobs claim all free memory")
+	}
+	if got := p.scaleDelta(100, 2, 2); got != 0 {
+		t.Errorf("scaleDelta without free memory = %d, want 0", got)
+	}
+
+	releaseMemory(second)
+	if p.memoryPressure() {
+		t.Error("memory pressure after a job ended")
+	}
+	releaseMemory(first)
+	if n := memoryReserved.Load(); n != 0 {
+		t.Errorf("%d bytes still reserved after every job ended", n)
+	}
+
+	// A reload changing the estimate does not unbalance running jobs
+	reserved := p.reserveMemory()
+	withConfig(t, func(c *Config) { c.Pools.Light.JobMemoryMB = 512 })
+	releaseMemory(reserved)
+	if n := memoryReserved.Load(); n != 0 {
+		t.Errorf("%d bytes still reserved after a reload", n)
+	}
+}
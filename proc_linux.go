@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// configureProcess runs the command in its own process group so that
+// helpers it spawns are killed along with it when the context ends
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+}
+
+// diskAvailable returns the free space of the filesystem holding path
+func diskAvailable(path string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
+
+// rlimitNproc is RLIMIT_NPROC, which package syscall does not export
+const rlimitNproc = 0x6
+
+// sandboxCommand runs name in dir with a scrubbed environment, through a
+// re-exec of this binary that applies the sandbox resource limits
+func sandboxCommand(ctx context.Context, dir, name string, args ...string) *exec.Cmd {
+	path, err := exec.LookPath(name)
+	self, selfErr := os.Executable()
+	if err != nil || selfErr != nil {
+		// Leaves cmd.Err set when name is missing, so Run reports it
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = dir
+		cmd.Env = sandboxEnv(dir)
+		configureProcess(cmd)
+		return cmd
+	}
+
+	cmd := exec.CommandContext(ctx, self, append([]string{sandboxExecArg, path}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = sandboxEnv(dir)
+	configureProcess(cmd)
+	return cmd
+}
+
+// sandboxExec is the launcher side of sandboxCommand: it sets the
+// resource limits and replaces itself with the converter
+func sandboxExec(args []string) {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_DATA, sandboxMemoryLimit},
+		{syscall.RLIMIT_CPU, sandboxCPULimit},
+		{syscall.RLIMIT_FSIZE, sandboxFileLimit},
+		{rlimitNproc, sandboxProcLimit},
+		{syscall.RLIMIT_CORE, 0},
+	}
+	for _, l := range limits {
+		if err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: setrlimit %d: %v\n", l.resource, err)
+			os.Exit(126)
+		}
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "sandbox: no command")
+		os.Exit(126)
+	}
+	err := syscall.Exec(args[0], args, os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: exec %s: %v\n", args[0], err)
+	os.Exit(127)
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// configureProcess only bounds how long Wait blocks after cancellation;
+// process groups are not used on this platform
+func configureProcess(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
+
+// sandboxCommand runs name in dir with a scrubbed environment. Resource
+// limits are not supported on this platform.
+func sandboxCommand(ctx context.Context, dir, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = sandboxEnv(dir)
+	configureProcess(cmd)
+	return cmd
+}
+
+// diskAvailable is not measured on this platform
+func diskAvailable(path string) (int64, bool) {
+	return 0, false
+}
+
+// sandboxExec is never used on this platform
+func sandboxExec(args []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: not supported on this platform")
+	os.Exit(126)
+}
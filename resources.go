@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// ResourcePolicy decides which remote resources a conversion may fetch.
+// Everything is denied unless its host is allowlisted, and private or
+// loopback addresses are refused even for allowlisted hosts.
+type ResourcePolicy struct {
+	AllowHosts []string `json:"allow_hosts"` // exact hosts or "*.example.com" patterns
+	MaxBytes   int64    `json:"max_bytes"`
+	MaxCount   int      `json:"max_count"`
+	Timeout    Duration `json:"timeout"`
+}
+
+// deadProxy is given to converter processes so any fetch they attempt on
+// their own fails; remote resources are only fetched by the policy
+const deadProxy = "http://127.0.0.1:9"
+
+// embeddingFormats are outputs for which pandoc fetches referenced images
+var embeddingFormats = map[string]bool{
+	"pdf": true, "docx": true, "odt": true, "epub": true, "pptx": true, "rtf": true,
+}
+
+// hostAllowed reports whether host matches the allowlist
+func (p *ResourcePolicy) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.AllowHosts {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedNets are the IANA special-purpose ranges, plus multicast and
+// prefixes that embed IPv4 addresses (NAT64, 6to4, Teredo), so fetches
+// only reach ordinary globally routed hosts
+var blockedNets = parseCIDRs(
+	// IPv4
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24",
+	"192.31.196.0/24", "192.52.193.0/24", "192.88.99.0/24", "192.168.0.0/16",
+	"192.175.48.0/24", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24",
+	"224.0.0.0/4", "240.0.0.0/4",
+	// IPv6; IPv4-mapped addresses are checked as IPv4
+	"::/96", "64:ff9b::/96", "64:ff9b:1::/48", "100::/64", "2001::/23",
+	"2001:db8::/32", "2002::/16", "2620:4f:8000::/48", "3fff::/20",
+	"5f00::/16", "fc00::/7", "fe80::/10", "fec0::/10", "ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// blockedIP reports whether ip is in a range fetches must never reach
+func blockedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns an HTTP client that checks every dialled address, so
+// DNS answers and redirects cannot lead to internal hosts
+func (p *ResourcePolicy) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: p.Timeout.Std(),
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+				return fmt.Errorf("address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: p.Timeout.Std(),
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: p.Timeout.Std(),
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			if !p.hostAllowed(req.URL.Hostname()) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
+
+// fetchImage downloads an allowed image and returns it as a data URI
+func (p *ResourcePolicy) fetchImage(ctx context.Context, client *http.Client, u *url.URL) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+	if resp.ContentLength > p.MaxBytes {
+		return "", fmt.Errorf("resource is larger than %d bytes", p.MaxBytes)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("resource is not an image (%s)", mediaType)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.MaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > p.MaxBytes {
+		return "", fmt.Errorf("resource is larger than %d bytes", p.MaxBytes)
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// remoteURL parses target when it refers to a network resource
+func remoteURL(target string) (*url.URL, bool) {
+	target = strings.TrimSpace(target)
+	if strings.HasPrefix(target, "//") {
+		target = "https:" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "ftp", "ftps":
+		return u, true
+	}
+	return nil, false
+}
+
+// rawRemoteRefRe finds remote references in raw HTML that an HTML PDF
+// engine would otherwise fetch
+var rawRemoteRefRe = regexp.MustCompile(`(?i)((src|href|data|poster|srcset|background)\s*=\s*["']?|url\(\s*["']?|@import\s+["']?)\s*((https?|ftps?):)?//`)
+
+// needsResourcePolicy reports whether a conversion must go through the
+// resource policy: text inputs that mention URLs, converted to an output
+// for which pandoc would fetch referenced images. The input is scanned in
+// fixed-size pieces rather than read whole.
+func needsResourcePolicy(fromFmt, toFmt, inputPath string) bool {
+	if !embeddingFormats[toFmt] || binaryFormats[fromFmt] || fromFmt == "json" {
+		return false
+	}
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32<<10)
+	var last byte
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			// A "//" may straddle two reads
+			if last == '/' && buf[0] == '/' || bytes.Contains(buf[:n], []byte("//")) {
+				return true
+			}
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			return true
+		}
+	}
+}
+
+// applyResourcePolicy parses the input to pandoc's JSON AST and rewrites
+// remote images: allowed ones are embedded as data URIs, others are
+// replaced by their description. Raw HTML with remote references is
+// dropped. It returns the request to run against the rewritten AST.
+func applyResourcePolicy(ctx context.Context, req ConvertRequest) (ConvertRequest, []Warning, error) {
+	astReq := req
+	astReq.ToFmt = "json"
+	astReq.OutputPath = req.InputPath + ".json"
+	res, err := pandocCLIConverter{}.Convert(ctx, astReq)
+	if err != nil {
+		os.Remove(astReq.OutputPath)
+		return req, nil, err
+	}
+	warnings := res.Warnings
+
+	data, err := os.ReadFile(astReq.OutputPath)
+	if err != nil {
+		return req, warnings, fmt.Errorf("failed to read document AST: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return req, warnings, fmt.Errorf("failed to parse document AST: %w", err)
+	}
+
+	policy := cfg().Resources
+	w := &resourceWalker{ctx: ctx, policy: &policy, client: policy.client()}
+	doc = w.walk(doc)
+	warnings = append(warnings, w.warnings...)
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return req, warnings, err
+	}
+	if err := os.WriteFile(astReq.OutputPath, out, 0600); err != nil {
+		return req, warnings, fmt.Errorf("failed to write document AST: %w", err)
+	}
+
+	req.InputPath = astReq.OutputPath
+	req.FromFmt = "json"
+	return req, warnings, nil
+}
+
+// resourceWalker rewrites remote references in a decoded pandoc AST
+type resourceWalker struct {
+	ctx      context.Context
+	policy   *ResourcePolicy
+	client   *http.Client
+	fetched  int
+	warnings []Warning
+}
+
+func (w *resourceWalker) block(target, reason string) {
+	w.warnings = append(w.warnings, Warning{
+		Type:    "BlockedResource",
+		Level:   "warning",
+		Message: fmt.Sprintf("Blocked remote resource %s: %s", target, reason),
+	})
+}
+
+// walk returns node with Image and raw HTML elements rewritten
+func (w *resourceWalker) walk(node interface{}) interface{} {
+	switch n := node.(type) {
+	case []interface{}:
+		for i := range n {
+			n[i] = w.walk(n[i])
+		}
+		return n
+	case map[string]interface{}:
+		switch n["t"] {
+		case "Image":
+			return w.image(n)
+		case "RawBlock", "RawInline":
+			if c, ok := n["c"].([]interface{}); ok && len(c) == 2 {
+				format, _ := c[0].(string)
+				text, _ := c[1].(string)
+				if (format == "html" || format == "html5" || format == "html4") && rawRemoteRefRe.MatchString(text) {
+					w.block("in raw HTML", "raw HTML may not reference remote resources")
+					c[1] = ""
+				}
+			}
+			return n
+		}
+		for k, v := range n {
+			n[k] = w.walk(v)
+		}
+		return n
+	}
+	return node
+}
+
+// image embeds or removes a remote image. Its c field is
+// [attr, description inlines, [url, title]].
+func (w *resourceWalker) image(n map[string]interface{}) interface{} {
+	c, ok := n["c"].([]interface{})
+	if !ok || len(c) != 3 {
+		return n
+	}
+	c[1] = w.walk(c[1])
+	target, ok := c[2].([]interface{})
+	if !ok || len(target) != 2 {
+		return n
+	}
+	src, _ := target[0].(string)
+	u, remote := remoteURL(src)
+	if !remote {
+		return n
+	}
+
+	var reason string
+	switch {
+	case !w.policy.hostAllowed(u.Hostname()):
+		reason = "host is not allowed"
+	case w.fetched >= w.policy.MaxCount:
+		reason = fmt.Sprintf("more than %d remote resources", w.policy.MaxCount)
+	default:
+		w.fetched++
+		dataURI, err := w.policy.fetchImage(w.ctx, w.client, u)
+		if err == nil {
+			target[0] = dataURI
+			return n
+		}
+		reason = err.Error()
+	}
+
+	// Keep the image's description in its place
+	w.block(src, reason)
+	return map[string]interface{}{
+		"t": "Span",
+		"c": []interface{}{c[0], c[1]},
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockedIP(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":              true,
+		"::1":                    true,
+		"0.0.0.0":                true,
+		"10.1.2.3":               true,
+		"172.16.0.1":             true,
+		"172.32.0.1":             false,
+		"192.168.1.1":            true,
+		"169.254.169.254":        true,
+		"100.64.0.1":             true,
+		"192.0.0.170":            true,
+		"192.0.2.1":              true,
+		"198.18.0.1":             true,
+		"198.19.255.255":         true,
+		"198.20.0.1":             false,
+		"198.51.100.7":           true,
+		"203.0.113.9":            true,
+		"224.0.0.251":            true,
+		"255.255.255.255":        true,
+		"::ffff:127.0.0.1":       true,
+		"::ffff:169.254.169.254": true,
+		"::ffff:8.8.8.8":         false,
+		"::127.0.0.1":            true,
+		"64:ff9b::a9fe:a9fe":     true,
+		"2002:7f00:1::":          true,
+		"2001:0:4136:e378::":     true,
+		"2001:db8::1":            true,
+		"fd00::1":                true,
+		"fe80::1":                true,
+		"ff02::1":                true,
+		"8.8.8.8":                false,
+		"93.184.216.34":          false,
+		"2606:4700::1111":        false,
+	}
+	for addr, want := range tests {
+		if got := blockedIP(net.ParseIP(addr)); got != want {
+			t.Errorf("blockedIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	p := &ResourcePolicy{AllowHosts: []string{"images.example.com", "*.CDN.example.org"}}
+	tests := map[string]bool{
+		"images.example.com":      true,
+		"IMAGES.example.com.":     true,
+		"evil-images.example.com": false,
+		"example.com":             false,
+		"a.cdn.example.org":       true,
+		"a.b.cdn.example.org":     true,
+		"cdn.example.org":         false,
+		"cdn.example.org.evil.io": false,
+		"":                        false,
+	}
+	for host, want := range tests {
+		if got := p.hostAllowed(host); got != want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if (&ResourcePolicy{}).hostAllowed("example.com") {
+		t.Error("empty allowlist allowed a host")
+	}
+}
+
+func TestPolicyClientRefusesInternalHosts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	p := &ResourcePolicy{AllowHosts: []string{"127.0.0.1", "images.example.com"}, Timeout: Duration(time.Second)}
+	client := p.client()
+
+	// Allowlisting does not open loopback addresses
+	if _, err := client.Get(srv.URL); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("request to a loopback server: %v", err)
+	}
+
+	via := []*http.Request{httptest.NewRequest("GET", "https://images.example.com/a.png", nil)}
+	tests := map[string]bool{
+		"https://images.example.com/b.png":         true,
+		"http://169.254.169.254/latest/meta-data/": false,
+		"https://internal.example.com/admin":       false,
+	}
+	for target, allowed := range tests {
+		err := client.CheckRedirect(httptest.NewRequest("GET", target, nil), via)
+		if (err == nil) != allowed {
+			t.Errorf("redirect to %s: %v", target, err)
+		}
+	}
+	if err := client.CheckRedirect(httptest.NewRequest("GET", "https://images.example.com/c.png", nil), make([]*http.Request, 3)); err == nil {
+		t.Error("redirect chain without limit")
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dot.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		case "/big.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	p := &ResourcePolicy{MaxBytes: 10}
+
+	fetch := func(path string) (string, error) {
+		u, _ := url.Parse(srv.URL + path)
+		return p.fetchImage(context.Background(), srv.Client(), u)
+	}
+	if got, err := fetch("/dot.png"); err != nil || got != "data:image/png;base64,cG5n" {
+		t.Errorf("fetch image = %q, %v", got, err)
+	}
+	for _, path := range []string{"/big.png", "/page.html", "/missing.png"} {
+		if _, err := fetch(path); err == nil {
+			t.Errorf("fetch %s succeeded", path)
+		}
+	}
+}
+
+// astImage builds a pandoc Image element with a one-word description
+func astImage(src string) map[string]interface{} {
+	return map[string]interface{}{
+		"t": "Image",
+		"c": []interface{}{
+			[]interface{}{"", []interface{}{}, []interface{}{}},
+			[]interface{}{map[string]interface{}{"t": "Str", "c": "logo"}},
+			[]interface{}{src, ""},
+		},
+	}
+}
+
+func TestResourceWalker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		w.Write([]byte("gif"))
+	}))
+	defer srv.Close()
+	allowed := strings.TrimPrefix(srv.URL, "http://")
+	host, _, _ := net.SplitHostPort(allowed)
+
+	doc := map[string]interface{}{
+		"blocks": []interface{}{
+			map[string]interface{}{"t": "Para", "c": []interface{}{
+				astImage("local.png"),
+				astImage(srv.URL + "/ok.gif"),
+				astImage("https://tracker.example.net/pixel.gif"),
+				astImage(srv.URL + "/second.gif"),
+			}},
+			map[string]interface{}{"t": "RawBlock", "c": []interface{}{"html", `<img src="//evil.example/x.png">`}},
+			map[string]interface{}{"t": "RawBlock", "c": []interface{}{"html", `<b>kept</b>`}},
+		},
+	}
+	policy := &ResourcePolicy{AllowHosts: []string{host}, MaxBytes: 1 << 10, MaxCount: 1}
+	w := &resourceWalker{ctx: context.Background(), policy: policy, client: srv.Client()}
+	w.walk(doc)
+
+	out, _ := json.Marshal(doc)
+	para := doc["blocks"].([]interface{})[0].(map[string]interface{})["c"].([]interface{})
+	kinds := []string{}
+	for _, n := range para {
+		kinds = append(kinds, n.(map[string]interface{})["t"].(string))
+	}
+	if strings.Join(kinds, ",") != "Image,Image,Span,Span" {
+		t.Errorf("inlines after the walk: %v", kinds)
+	}
+	if !strings.Contains(string(out), `"local.png"`) || !strings.Contains(string(out), "data:image/gif;base64,Z2lm") {
+		t.Errorf("local image or embedded data URI missing: %s", out)
+	}
+	if strings.Contains(string(out), "tracker.example.net") || strings.Contains(string(out), "second.gif") {
+		t.Errorf("blocked image kept its target: %s", out)
+	}
+	raw := doc["blocks"].([]interface{})
+	if got := raw[1].(map[string]interface{})["c"].([]interface{})[1]; got != "" {
+		t.Errorf("raw HTML with a remote reference kept: %q", got)
+	}
+	if got := raw[2].(map[string]interface{})["c"].([]interface{})[1]; got != "<b>kept</b>" {
+		t.Errorf("local raw HTML changed: %q", got)
+	}
+	if len(w.warnings) != 3 {
+		t.Errorf("warnings = %+v, want one per blocked resource", w.warnings)
+	}
+}
+
+func TestNeedsResourcePolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0600)
+		return path
+	}
+	plain := write("plain.md", strings.Repeat("a", 100<<10))
+	// The "//" falls across the scanner's 32 KiB reads
+	split := write("split.md", strings.Repeat("a", 32<<10-1)+"//host/x.png")
+	remote := write("url.md", "![x](https://example.com/x.png)")
+
+	tests := []struct {
+		from, to, path string
+		want           bool
+	}{
+		{"markdown", "pdf", plain, false},
+		{"markdown", "pdf", split, true},
+		{"markdown", "docx", remote, true},
+		{"markdown", "html", remote, false},
+		{"docx", "pdf", remote, false},
+		{"json", "pdf", remote, false},
+		{"markdown", "pdf", filepath.Join(dir, "missing.md"), true},
+	}
+	for _, tt := range tests {
+		if got := needsResourcePolicy(tt.from, tt.to, tt.path); got != tt.want {
+			t.Errorf("needsResourcePolicy(%s, %s, %s) = %v, want %v", tt.from, tt.to, filepath.Base(tt.path), got, tt.want)
+		}
+	}
+}
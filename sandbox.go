@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Resource limits for converter processes
+const (
+	sandboxMemoryLimit = 2 << 30   // bytes of data segment
+	sandboxCPULimit    = 120       // seconds of CPU time
+	sandboxFileLimit   = 512 << 20 // bytes per written file
+	sandboxProcLimit   = 256       // processes for the user
+)
+
+// sandboxExecArg re-executes this binary as a launcher that applies the
+// resource limits before exec'ing the real converter
+const sandboxExecArg = "__sandbox-exec"
+
+// pandocSandboxSupported is set when pandoc accepts --sandbox (2.15+)
+var pandocSandboxSupported bool
+
+// detectSandboxSupport checks once at startup whether pandoc can sandbox
+// its readers and writers
+func detectSandboxSupport() {
+	if err := exec.Command("pandoc", "--sandbox", "--version").Run(); err != nil {
+		log.Printf("pandoc does not support --sandbox; document I/O is not restricted by pandoc")
+		return
+	}
+	pandocSandboxSupported = true
+}
+
+// sandboxEnv returns the scrubbed environment for a converter process
+// running in dir. TeX engines get shell escape disabled and may only open
+// files below the working directory, and HTTP clients that honour proxy
+// variables are pointed at a dead proxy.
+func sandboxEnv(dir string) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"LANG=C.UTF-8",
+		"LC_ALL=C.UTF-8",
+		"shell_escape=f",
+		"openin_any=p",
+		"openout_any=p",
+		"TEXMFOUTPUT=" + dir,
+		"TEXMFVAR=" + filepath.Join(dir, ".texmf-var"),
+		"http_proxy=" + deadProxy,
+		"https_proxy=" + deadProxy,
+		"HTTP_PROXY=" + deadProxy,
+		"HTTPS_PROXY=" + deadProxy,
+		"ALL_PROXY=" + deadProxy,
+		"no_proxy=",
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary act as the sandbox launcher, which
+// converters re-execute through sandboxCommand
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == sandboxExecArg {
+		sandboxExec(os.Args[2:])
+	}
+	os.Exit(m.Run())
+}
+
+// sandboxVector is a malicious input that tries to escape the sandbox.
+// %[1]s in Input is replaced by the path of a secret file, %[2]s by the
+// path of a marker file that must never be created and %[3]s by the URL of
+// a loopback server that must never be contacted.
+type sandboxVector struct {
+	Name    string
+	FromFmt string
+	ToFmt   string
+	Input   string
+	Engine  string // kind of PDF engine the vector targets
+}
+
+var sandboxVectors = []sandboxVector{
+	{Name: "markdown image reads local file", FromFmt: "markdown", ToFmt: "docx",
+		Input: "![x](%[1]s)\n"},
+	{Name: "html image reads local file", FromFmt: "html", ToFmt: "docx",
+		Input: "<p><img src=\"file://%[1]s\"></p>\n"},
+	{Name: "rst include directive", FromFmt: "rst", ToFmt: "html",
+		Input: ".. include:: %[1]s\n"},
+	{Name: "org include directive", FromFmt: "org", ToFmt: "html",
+		Input: "#+INCLUDE: \"%[1]s\"\n"},
+	{Name: "latex reader input", FromFmt: "latex", ToFmt: "html",
+		Input: "\\input{%[1]s}\n"},
+	{Name: "tex engine input", FromFmt: "markdown", ToFmt: "pdf", Engine: engineLaTeX,
+		Input: "```{=latex}\n\\input{%[1]s}\n```\n"},
+	{Name: "tex engine shell escape", FromFmt: "markdown", ToFmt: "pdf", Engine: engineLaTeX,
+		Input: "```{=latex}\n\\immediate\\write18{touch %[2]s}\n```\n"},
+	{Name: "tex engine writes outside workdir", FromFmt: "markdown", ToFmt: "pdf", Engine: engineLaTeX,
+		Input: "```{=latex}\n\\newwrite\\f\\immediate\\openout\\f=%[2]s\\immediate\\write\\f{x}\\immediate\\closeout\\f\n```\n"},
+	{Name: "markdown remote image fetches loopback", FromFmt: "markdown", ToFmt: "docx",
+		Input: "![x](%[3]s/image.png)\n"},
+	{Name: "html remote image fetches loopback", FromFmt: "html", ToFmt: "odt",
+		Input: "<p><img src=\"%[3]s/image.png\"></p>\n"},
+	{Name: "raw html stylesheet fetches loopback", FromFmt: "markdown", ToFmt: "pdf", Engine: engineHTML,
+		Input: "```{=html}\n<link rel=\"stylesheet\" href=\"%[3]s/style.css\">\n```\n\ntext\n"},
+}
+
+// TestSandboxVectors converts each malicious input and checks that the
+// secret never reaches the output, no marker file is written and the
+// loopback server is never contacted
+func TestSandboxVectors(t *testing.T) {
+	if _, err := exec.LookPath("pandoc"); err != nil {
+		t.Skip("pandoc is not installed")
+	}
+	detectPDFEngines()
+	detectSandboxSupport()
+
+	secretDir := t.TempDir()
+	secret := fmt.Sprintf("CONVERTLY-SECRET-%d", time.Now().UnixNano())
+	secretPath := filepath.Join(secretDir, "secret.txt")
+	if err := os.WriteFile(secretPath, []byte(secret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any request reaching this server means a fetch escaped the policy
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var hits atomic.Int64
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(secret))
+	}))
+	serverURL := "http://" + listener.Addr().String()
+
+	for i, v := range sandboxVectors {
+		t.Run(v.Name, func(t *testing.T) {
+			engine := ""
+			if v.Engine != "" {
+				engine = availableEngineOfKind(v.Engine)
+				if engine == "" {
+					t.Skipf("no %s PDF engine installed", v.Engine)
+				}
+				if _, err := exec.LookPath("pdftotext"); err != nil {
+					t.Skip("pdftotext is not installed")
+				}
+			}
+
+			markerPath := filepath.Join(secretDir, fmt.Sprintf("marker_%d", i))
+			before := hits.Load()
+			outputPath, convErr := convertSandboxVector(t, v, engine, fmt.Sprintf(v.Input, secretPath, markerPath, serverURL))
+
+			if hits.Load() != before {
+				t.Error("conversion contacted the loopback server")
+			}
+			if _, err := os.Stat(markerPath); err == nil {
+				t.Error("conversion wrote the marker file")
+			}
+			if convErr != nil {
+				// A failed conversion cannot leak the secret into its output
+				return
+			}
+			text, err := outputText(outputPath)
+			if err != nil {
+				t.Fatalf("cannot verify output: %v", err)
+			}
+			if strings.Contains(text, secret) {
+				t.Error("secret leaked into the output")
+			}
+		})
+	}
+}
+
+// availableEngineOfKind returns the first installed PDF engine of a kind
+func availableEngineOfKind(kind string) string {
+	for _, e := range availablePDFEngines {
+		if pdfEngineKinds[e] == kind {
+			return e
+		}
+	}
+	return ""
+}
+
+// convertSandboxVector runs one input through the resource policy and the
+// CLI backend, returning the output path and the conversion error
+func convertSandboxVector(t *testing.T, v sandboxVector, engine, input string) (string, error) {
+	workDir := t.TempDir()
+	inputPath := filepath.Join(workDir, "input"+formatExtensions[v.FromFmt])
+	if err := os.WriteFile(inputPath, []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	req := ConvertRequest{
+		JobID:      "sandbox-test",
+		InputPath:  inputPath,
+		FromFmt:    v.FromFmt,
+		ToFmt:      v.ToFmt,
+		OutputPath: filepath.Join(workDir, "output"+formatExtensions[v.ToFmt]),
+		PDFEngine:  engine,
+		WorkDir:    workDir,
+	}
+	var err error
+	if needsResourcePolicy(req.FromFmt, req.ToFmt, req.InputPath) {
+		if req, _, err = applyResourcePolicy(ctx, req); err != nil {
+			return "", err
+		}
+	}
+	_, err = pandocCLIConverter{}.Convert(ctx, req)
+	return req.OutputPath, err
+}
+
+// outputText returns the searchable text of a conversion output: the
+// entries of ZIP containers, pdftotext output for PDF, or the raw bytes
+func outputText(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.HasPrefix(data, []byte("%PDF")) {
+		out, err := exec.Command("pdftotext", path, "-").Output()
+		return string(out), err
+	}
+
+	if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		var sb strings.Builder
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				return "", err
+			}
+			io.Copy(&sb, rc)
+			rc.Close()
+		}
+		return sb.String(), nil
+	}
+	return string(data), nil
+}
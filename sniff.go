@@ -0,0 +1,262 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Detection confidence levels reported with sniffed formats
+const (
+	confidenceHigh   = 0.95
+	confidenceMedium = 0.7
+	confidenceLow    = 0.4
+)
+
+// sniffHeadSize is how much of a file is inspected for text-based formats
+const sniffHeadSize = 64 << 10
+
+// Detection describes how an input format was determined
+type Detection struct {
+	Format     string  `json:"format"`
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source"` // "content_type", "extension", "content" or "default"
+}
+
+// ZIP mimetype entries used by OpenDocument and EPUB containers
+var zipMimetypes = map[string]string{
+	"application/vnd.oasis.opendocument.text":        "odt",
+	"application/epub+zip":                           "epub",
+	"application/vnd.oasis.opendocument.spreadsheet": "ods",
+}
+
+// sniffContent inspects the leading bytes (and ZIP contents) of a file of
+// the given size and returns the detected format with a confidence
+// between 0 and 1
+func sniffContent(r io.ReaderAt, size int64) (string, float64) {
+	head := make([]byte, min(sniffHeadSize, size))
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return sniffZip(r, size)
+	}
+	return sniffBytes(head)
+}
+
+// sniffZip identifies office and ebook formats by their container layout
+func sniffZip(r io.ReaderAt, size int64) (string, float64) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", 0
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "mimetype" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			break
+		}
+		mt, _ := io.ReadAll(io.LimitReader(rc, 256))
+		rc.Close()
+		if format, ok := zipMimetypes[strings.TrimSpace(string(mt))]; ok {
+			return format, confidenceHigh
+		}
+	}
+
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "word/document.xml":
+			return "docx", confidenceHigh
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return "pptx", confidenceHigh
+		case f.Name == "xl/workbook.xml":
+			return "xlsx", confidenceHigh
+		}
+	}
+	return "", 0
+}
+
+// sniffBytes detects text and simple binary formats from a content prefix
+func sniffBytes(head []byte) (string, float64) {
+	if bytes.HasPrefix(head, []byte("%PDF-")) {
+		return "pdf", confidenceHigh
+	}
+
+	// Sniff text in other encodings on its UTF-8 form
+	text := trimPartialRune(head)
+	if enc := detectEncoding(text); enc != encUTF8 {
+		if decoded, err := decodeToUTF8(text, enc); err == nil {
+			text = decoded
+		}
+	}
+	text = bytes.TrimPrefix(text, []byte("\xef\xbb\xbf"))
+	text = bytes.TrimLeft(text, " \t\r\n")
+	if len(text) == 0 {
+		return "", 0
+	}
+
+	if bytes.HasPrefix(text, []byte(`{\rtf`)) {
+		return "rtf", confidenceHigh
+	}
+
+	lower := bytes.ToLower(text[:min(len(text), 1024)])
+	if bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html")) {
+		return "html", confidenceHigh
+	}
+
+	if text[0] == '{' {
+		if format, conf := sniffJSON(text); format != "" {
+			return format, conf
+		}
+	}
+
+	if text[0] == '<' {
+		if format, conf := sniffXML(text); format != "" {
+			return format, conf
+		}
+		if bytes.Contains(lower, []byte("<body")) || bytes.Contains(lower, []byte("<div")) || bytes.Contains(lower, []byte("<p>")) {
+			return "html", confidenceMedium
+		}
+	}
+
+	return sniffPlainText(text)
+}
+
+// sniffJSON distinguishes Jupyter notebooks from Pandoc JSON ASTs
+func sniffJSON(text []byte) (string, float64) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(text, &doc); err != nil {
+		// Possibly truncated by the sniff window - fall back to key lookups
+		if bytes.Contains(text, []byte(`"nbformat"`)) && bytes.Contains(text, []byte(`"cells"`)) {
+			return "ipynb", confidenceMedium
+		}
+		if bytes.Contains(text, []byte(`"pandoc-api-version"`)) {
+			return "json", confidenceMedium
+		}
+		return "", 0
+	}
+
+	if _, ok := doc["nbformat"]; ok {
+		if _, ok := doc["cells"]; ok {
+			return "ipynb", confidenceHigh
+		}
+	}
+	if _, ok := doc["pandoc-api-version"]; ok {
+		if _, ok := doc["blocks"]; ok {
+			return "json", confidenceHigh
+		}
+	}
+	return "", 0
+}
+
+// docbookNamespace is the namespace of DocBook 5 documents
+const docbookNamespace = "http://docbook.org/ns/docbook"
+
+// sniffXML detects XML dialects by their root element. <article> and
+// <section> are also HTML elements, so those roots are DocBook only with
+// the DocBook namespace or DOCTYPE.
+func sniffXML(text []byte) (string, float64) {
+	dec := xml.NewDecoder(bytes.NewReader(text))
+	dec.Strict = false
+	docbookDoctype := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", 0
+		}
+		if d, ok := tok.(xml.Directive); ok && strings.Contains(strings.ToLower(string(d)), "docbook") {
+			docbookDoctype = true
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		docbook := start.Name.Space == docbookNamespace || docbookDoctype
+		switch strings.ToLower(start.Name.Local) {
+		case "book", "chapter":
+			if docbook {
+				return "docbook", confidenceHigh
+			}
+			return "docbook", confidenceMedium
+		case "article", "section":
+			if docbook {
+				return "docbook", confidenceHigh
+			}
+			return "html", confidenceMedium
+		case "fictionbook":
+			return "fb2", confidenceHigh
+		case "opml":
+			return "opml", confidenceHigh
+		case "html":
+			return "html", confidenceHigh
+		}
+		return "", 0
+	}
+}
+
+// sniffPlainText applies heuristics for lightweight markup formats
+func sniffPlainText(text []byte) (string, float64) {
+	s := string(text)
+	switch {
+	case strings.Contains(s, `\documentclass`) || strings.Contains(s, `\begin{document}`):
+		return "latex", confidenceHigh
+	case strings.HasPrefix(s, "#+TITLE") || strings.HasPrefix(s, "#+title"):
+		return "org", confidenceMedium
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "## ") || strings.HasPrefix(line, "```"):
+			return "markdown", confidenceMedium
+		case strings.HasPrefix(line, ".. ") && strings.Contains(line, "::"):
+			return "rst", confidenceMedium
+		case strings.HasPrefix(line, "= ") && strings.HasSuffix(line, " ="):
+			return "mediawiki", confidenceLow
+		}
+	}
+	return "", 0
+}
+
+// detectInputFormat resolves the input format for an uploaded file of the
+// given size. The extension is preferred unless preferContent is set, in which case the
+// sniffed content format wins whenever one is found.
+func detectInputFormat(r io.ReaderAt, size int64, ext string, preferContent bool) Detection {
+	extFormat, hasExt := extensionFormats[strings.ToLower(ext)]
+
+	if hasExt && !preferContent {
+		return Detection{Format: extFormat, Confidence: confidenceMedium, Source: "extension"}
+	}
+
+	if format, conf := sniffContent(r, size); format != "" {
+		if hasExt && format == extFormat {
+			conf = confidenceHigh
+		}
+		return Detection{Format: format, Confidence: conf, Source: "content"}
+	}
+
+	if hasExt {
+		return Detection{Format: extFormat, Confidence: confidenceLow, Source: "extension"}
+	}
+	return Detection{Format: "markdown", Confidence: 0, Source: "default"}
+}
+
+// trimPartialRune drops a multi-byte rune cut off by the sniff window
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+// zipWith builds a ZIP archive holding the named entries
+func zipWith(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSniffBytes(t *testing.T) {
+	tests := []struct {
+		name, head, want string
+	}{
+		{"pdf", "%PDF-1.7\n", "pdf"},
+		{"rtf", `{\rtf1\ansi hello}`, "rtf"},
+		{"html doctype", "\xef\xbb\xbf  <!DOCTYPE html><html></html>", "html"},
+		{"html fragment", "<div><p>hi</p></div>", "html"},
+		{"docbook", `<article xmlns="http://docbook.org/ns/docbook"><title>x</title></article>`, "docbook"},
+		{"docbook doctype", `<?xml version="1.0"?><!DOCTYPE article PUBLIC "-//OASIS//DTD DocBook XML V4.5//EN" "docbookx.dtd"><article><title>x</title></article>`, "docbook"},
+		{"docbook section", `<section xmlns="http://docbook.org/ns/docbook" version="5.0"><title>x</title></section>`, "docbook"},
+		{"docbook book", `<book><title>x</title></book>`, "docbook"},
+		{"html article", `<article><h1>News</h1><p>text</p></article>`, "html"},
+		{"html section", `<section class="intro"><p>text</p></section>`, "html"},
+		{"fb2", `<?xml version="1.0"?><FictionBook></FictionBook>`, "fb2"},
+		{"opml", `<opml version="2.0"></opml>`, "opml"},
+		{"ipynb", `{"cells": [], "nbformat": 4}`, "ipynb"},
+		{"truncated ipynb", `{"cells": [{"source": "`, ""},
+		{"pandoc json", `{"pandoc-api-version": [1, 23], "meta": {}, "blocks": []}`, "json"},
+		{"other json", `{"name": "x"}`, ""},
+		{"latex", "\\documentclass{article}\n", "latex"},
+		{"org", "#+TITLE: Notes\n", "org"},
+		{"markdown", "Intro\n\n## Section\n", "markdown"},
+		{"rst", ".. note:: careful\n", "rst"},
+		{"mediawiki", "= Title =\n", "mediawiki"},
+		{"utf-16 markdown", "\xff\xfe#\x00 \x00T\x00\n\x00", "markdown"},
+		{"plain", "just some words", ""},
+		{"empty", "  \n", ""},
+	}
+	for _, tt := range tests {
+		if got, _ := sniffBytes([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: sniffBytes = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSniffContentZip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		want    string
+	}{
+		{"docx", map[string]string{"[Content_Types].xml": "", "word/document.xml": ""}, "docx"},
+		{"pptx", map[string]string{"ppt/presentation.xml": ""}, "pptx"},
+		{"xlsx", map[string]string{"xl/workbook.xml": ""}, "xlsx"},
+		{"odt", map[string]string{"mimetype": "application/vnd.oasis.opendocument.text", "content.xml": ""}, "odt"},
+		{"ods", map[string]string{"mimetype": "application/vnd.oasis.opendocument.spreadsheet"}, "ods"},
+		{"epub", map[string]string{"mimetype": "application/epub+zip\n", "META-INF/container.xml": ""}, "epub"},
+		{"plain zip", map[string]string{"readme.txt": "hi"}, ""},
+	}
+	for _, tt := range tests {
+		data := zipWith(t, tt.entries)
+		if got, _ := sniffContent(bytes.NewReader(data), int64(len(data))); got != tt.want {
+			t.Errorf("%s: sniffContent = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A ZIP signature on a corrupt archive is not trusted
+	data := []byte("PK\x03\x04 not really a zip")
+	if got, _ := sniffContent(bytes.NewReader(data), int64(len(data))); got != "" {
+		t.Errorf("corrupt zip: sniffContent = %q, want none", got)
+	}
+}
+
+func TestDetectInputFormat(t *testing.T) {
+	tests := []struct {
+		name, content, ext string
+		preferContent      bool
+		want               Detection
+	}{
+		{"extension", "%PDF-1.4", ".md", false, Detection{"markdown", confidenceMedium, "extension"}},
+		{"content wins", "%PDF-1.4", ".md", true, Detection{"pdf", confidenceHigh, "content"}},
+		{"content agrees", "# Title\n", ".MD", true, Detection{"markdown", confidenceHigh, "content"}},
+		{"no extension", "# Title\n", "", false, Detection{"markdown", confidenceMedium, "content"}},
+		{"unsniffable", "words", ".html", true, Detection{"html", confidenceLow, "extension"}},
+		{"default", "words", ".unknown", false, Detection{"markdown", 0, "default"}},
+	}
+	for _, tt := range tests {
+		r := bytes.NewReader([]byte(tt.content))
+		if got := detectInputFormat(r, r.Size(), tt.ext, tt.preferContent); got != tt.want {
+			t.Errorf("%s: detectInputFormat = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrimPartialRune(t *testing.T) {
+	tests := map[string]string{
+		"abc":           "abc",
+		"caf\xc3\xa9":   "caf\xc3\xa9",
+		"caf\xc3":       "caf",
+		"x\xe2\x82":     "x",
+		"x\xe2\x82\xac": "x\xe2\x82\xac",
+		"":              "",
+	}
+	for in, want := range tests {
+		if got := string(trimPartialRune([]byte(in))); got != want {
+			t.Errorf("trimPartialRune(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxSheetCells bounds how many cells a single sheet may expand to
+const maxSheetCells = 2_000_000
+
+// Sheet dimensions of Excel and LibreOffice; references beyond them are
+// invalid
+const (
+	maxSheetCols = 16384 // column XFD
+	maxSheetRows = 1048576
+)
+
+// SheetOptions controls how spreadsheet inputs are turned into tables
+type SheetOptions struct {
+	Sheet     string // sheet name or 1-based index; empty selects all sheets
+	HeaderRow int    // 1-based row within the range used as header; 0 for none
+	CellRange string // optional A1-style range such as "B2:F40"
+}
+
+// sheet is a parsed worksheet as a grid of cell strings
+type sheet struct {
+	Name string
+	Rows [][]string
+}
+
+// cellRange is a 1-based inclusive rectangle of cells
+type cellRange struct {
+	col1, row1, col2, row2 int
+}
+
+// readSpreadsheet converts an XLSX or ODS file into an HTML document with
+// one table per selected sheet
+func readSpreadsheet(ctx context.Context, job Job, inputPath, outPath string) error {
+	var (
+		sheets []sheet
+		err    error
+	)
+	switch job.FromFmt {
+	case "xlsx":
+		sheets, err = parseXLSX(inputPath)
+	case "ods":
+		sheets, err = parseODS(inputPath)
+	default:
+		err = fmt.Errorf("unsupported spreadsheet format: %s", job.FromFmt)
+	}
+	if err != nil {
+		return err
+	}
+
+	sheets, err = selectSheets(sheets, job.Sheet.Sheet)
+	if err != nil {
+		return err
+	}
+
+	var rng *cellRange
+	if job.Sheet.CellRange != "" {
+		if rng, err = parseCellRange(job.Sheet.CellRange); err != nil {
+			return err
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"></head>\n<body>\n")
+	for _, s := range sheets {
+		rows := s.Rows
+		if rng != nil {
+			if rows, err = applyCellRange(rows, *rng); err != nil {
+				return err
+			}
+		}
+		writeSheetTable(&out, s.Name, trimGrid(rows), job.Sheet.HeaderRow)
+	}
+	out.WriteString("</body>\n</html>\n")
+
+	return os.WriteFile(outPath, []byte(out.String()), 0600)
+}
+
+// selectSheets filters sheets by name or 1-based index
+func selectSheets(sheets []sheet, sel string) ([]sheet, error) {
+	if sel == "" {
+		return sheets, nil
+	}
+	for _, s := range sheets {
+		if s.Name == sel {
+			return []sheet{s}, nil
+		}
+	}
+	if n, err := strconv.Atoi(sel); err == nil && n >= 1 && n <= len(sheets) {
+		return []sheet{sheets[n-1]}, nil
+	}
+	return nil, fmt.Errorf("sheet %q not found", sel)
+}
+
+// parseCellRange parses an A1-style range like "B2:F40" (or a single cell)
+func parseCellRange(s string) (*cellRange, error) {
+	parts := strings.SplitN(strings.ToUpper(strings.TrimSpace(s)), ":", 2)
+	c1, r1, err := parseCellRef(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cell range %q", s)
+	}
+	c2, r2 := c1, r1
+	if len(parts) == 2 {
+		if c2, r2, err = parseCellRef(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid cell range %q", s)
+		}
+	}
+	if c2 < c1 {
+		c1, c2 = c2, c1
+	}
+	if r2 < r1 {
+		r1, r2 = r2, r1
+	}
+	return &cellRange{col1: c1, row1: r1, col2: c2, row2: r2}, nil
+}
+
+// parseCellRef converts "AB12" to 1-based column and row numbers, up to
+// XFD1048576
+func parseCellRef(ref string) (col, row int, err error) {
+	i := 0
+	for i < len(ref) && i < 3 && ref[i] >= 'A' && ref[i] <= 'Z' {
+		col = col*26 + int(ref[i]-'A'+1)
+		i++
+	}
+	if i == 0 || i == len(ref) || col > maxSheetCols {
+		return 0, 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	row, err = strconv.Atoi(ref[i:])
+	if err != nil || row < 1 || row > maxSheetRows {
+		return 0, 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return col, row, nil
+}
+
+// applyCellRange crops a grid to the given range, failing when the crop
+// would exceed maxSheetCells
+func applyCellRange(rows [][]string, rng cellRange) ([][]string, error) {
+	height := min(rng.row2, len(rows)) - rng.row1 + 1
+	if width := rng.col2 - rng.col1 + 1; height > 0 && width*height > maxSheetCells {
+		return nil, fmt.Errorf("cell range is too large: %d columns by %d rows", width, height)
+	}
+	var out [][]string
+	for r := rng.row1; r <= rng.row2 && r <= len(rows); r++ {
+		row := rows[r-1]
+		var cropped []string
+		for c := rng.col1; c <= rng.col2; c++ {
+			if c <= len(row) {
+				cropped = append(cropped, row[c-1])
+			} else {
+				cropped = append(cropped, "")
+			}
+		}
+		out = append(out, cropped)
+	}
+	return out, nil
+}
+
+// trimGrid drops trailing empty rows and columns and pads rows to equal width
+func trimGrid(rows [][]string) [][]string {
+	for len(rows) > 0 && isEmptyRow(rows[len(rows)-1]) {
+		rows = rows[:len(rows)-1]
+	}
+	width := 0
+	for _, row := range rows {
+		for c := len(row); c > width; c-- {
+			if strings.TrimSpace(row[c-1]) != "" {
+				width = c
+				break
+			}
+		}
+	}
+	out := make([][]string, len(rows))
+	for i, row := range rows {
+		padded := make([]string, width)
+		copy(padded, row)
+		out[i] = padded
+	}
+	return out
+}
+
+func isEmptyRow(row []string) bool {
+	for _, c := range row {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// writeSheetTable emits a sheet as a titled HTML table
+func writeSheetTable(out *strings.Builder, name string, rows [][]string, headerRow int) {
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "<h2>%s</h2>\n<table>\n", html.EscapeString(name))
+	if headerRow >= 1 && headerRow <= len(rows) {
+		out.WriteString("<thead>\n")
+		writeSheetRow(out, rows[headerRow-1], "th")
+		out.WriteString("</thead>\n")
+		rows = rows[headerRow:]
+	}
+	out.WriteString("<tbody>\n")
+	for _, row := range rows {
+		writeSheetRow(out, row, "td")
+	}
+	out.WriteString("</tbody>\n</table>\n")
+}
+
+func writeSheetRow(out *strings.Builder, row []string, tag string) {
+	out.WriteString("<tr>")
+	for _, c := range row {
+		fmt.Fprintf(out, "<%s>%s</%s>", tag, html.EscapeString(c), tag)
+	}
+	out.WriteString("</tr>\n")
+}
+
+// readZipEntry returns the contents of a named file inside a ZIP archive.
+// Entries that decompress past max_archive_entry_mb are rejected, whatever
+// size the archive declares for them.
+func readZipEntry(zr *zip.Reader, name string) ([]byte, error) {
+	limit := int64(cfg().MaxArchiveEntryMB) << 20
+	for _, f := range zr.File {
+		if f.Name == name {
+			if f.UncompressedSize64 > uint64(limit) {
+				return nil, fmt.Errorf("%s is larger than %d MB", name, limit>>20)
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+			if err == nil && int64(len(data)) > limit {
+				err = fmt.Errorf("%s is larger than %d MB", name, limit>>20)
+			}
+			return data, err
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// XLSX workbook structures
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRels struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxRichText `xml:"si"`
+}
+
+type xlsxRichText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (rt xlsxRichText) text() string {
+	if len(rt.Runs) == 0 {
+		return rt.T
+	}
+	var b strings.Builder
+	for _, r := range rt.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Ref    string       `xml:"r,attr"`
+			Type   string       `xml:"t,attr"`
+			Value  string       `xml:"v"`
+			Inline xlsxRichText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// parseXLSX reads every worksheet of an Office Open XML workbook
+func parseXLSX(filePath string) ([]sheet, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLSX: %w", err)
+	}
+	defer zr.Close()
+
+	var wb xlsxWorkbook
+	if data, err := readZipEntry(&zr.Reader, "xl/workbook.xml"); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("invalid XLSX: missing workbook")
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid XLSX: %w", err)
+	} else if err := xml.Unmarshal(data, &wb); err != nil {
+		return nil, fmt.Errorf("invalid XLSX workbook: %w", err)
+	}
+
+	targets := make(map[string]string)
+	if data, err := readZipEntry(&zr.Reader, "xl/_rels/workbook.xml.rels"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("invalid XLSX: %w", err)
+	} else if err == nil {
+		var rels xlsxRels
+		if err := xml.Unmarshal(data, &rels); err == nil {
+			for _, r := range rels.Relationships {
+				if strings.HasPrefix(r.Target, "/") {
+					targets[r.ID] = strings.TrimPrefix(r.Target, "/")
+				} else {
+					targets[r.ID] = path.Join("xl", r.Target)
+				}
+			}
+		}
+	}
+
+	var shared []string
+	if data, err := readZipEntry(&zr.Reader, "xl/sharedStrings.xml"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("invalid XLSX: %w", err)
+	} else if err == nil {
+		var ss xlsxSharedStrings
+		if err := xml.Unmarshal(data, &ss); err != nil {
+			return nil, fmt.Errorf("invalid XLSX shared strings: %w", err)
+		}
+		for _, si := range ss.Items {
+			shared = append(shared, si.text())
+		}
+	}
+
+	var sheets []sheet
+	for i, s := range wb.Sheets {
+		target, ok := targets[s.RID]
+		if !ok {
+			target = fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		}
+		data, err := readZipEntry(&zr.Reader, target)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("invalid XLSX: missing worksheet %q", s.Name)
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid XLSX worksheet %q: %w", s.Name, err)
+		}
+		var ws xlsxWorksheet
+		if err := xml.Unmarshal(data, &ws); err != nil {
+			return nil, fmt.Errorf("invalid XLSX worksheet %q: %w", s.Name, err)
+		}
+
+		var rows [][]string
+		cells := 0
+		for _, row := range ws.Rows {
+			r := row.R
+			if r == 0 {
+				r = len(rows) + 1
+			}
+			if r < 1 || r > maxSheetRows {
+				return nil, fmt.Errorf("invalid XLSX worksheet %q: row %d out of range", s.Name, row.R)
+			}
+			if r > len(rows) {
+				if cells += r - len(rows); cells > maxSheetCells {
+					return nil, fmt.Errorf("worksheet %q is too large", s.Name)
+				}
+				rows = append(rows, make([][]string, r-len(rows))...)
+			}
+			for j, c := range row.Cells {
+				col := j + 1
+				if c.Ref != "" {
+					if cc, _, err := parseCellRef(c.Ref); err == nil {
+						col = cc
+					}
+				}
+				if col > len(rows[r-1]) {
+					if cells += col - len(rows[r-1]); cells > maxSheetCells {
+						return nil, fmt.Errorf("worksheet %q is too large", s.Name)
+					}
+					rows[r-1] = append(rows[r-1], make([]string, col-len(rows[r-1]))...)
+				}
+				rows[r-1][col-1] = xlsxCellValue(c.Type, c.Value, c.Inline, shared)
+			}
+		}
+		sheets = append(sheets, sheet{Name: s.Name, Rows: rows})
+	}
+	return sheets, nil
+}
+
+// xlsxCellValue resolves a cell's display text from its type
+func xlsxCellValue(typ, value string, inline xlsxRichText, shared []string) string {
+	switch typ {
+	case "s":
+		if i, err := strconv.Atoi(value); err == nil && i >= 0 && i < len(shared) {
+			return shared[i]
+		}
+		return ""
+	case "inlineStr":
+		return inline.text()
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return value
+}
+
+// OpenDocument namespaces
+const (
+	odsTableNS  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	odsTextNS   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+	odsOfficeNS = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+)
+
+// parseODS reads every table of an OpenDocument spreadsheet
+func parseODS(filePath string) ([]sheet, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ODS: %w", err)
+	}
+	defer zr.Close()
+
+	data, err := readZipEntry(&zr.Reader, "content.xml")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("invalid ODS: missing content.xml")
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid ODS: %w", err)
+	}
+
+	var (
+		sheets   []sheet
+		cur      *sheet
+		row      []string
+		rowRep   int
+		cell     strings.Builder
+		cellVal  string
+		cellRep  int
+		inCell   bool
+		paraSeen bool
+		cells    int
+
+		// Blank rows and cells are often repeated to the sheet edge, so they
+		// are only materialized once non-empty content follows them
+		pendingRows  int
+		pendingCells int
+	)
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid ODS content: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == odsTableNS && t.Name.Local == "table":
+				sheets = append(sheets, sheet{Name: odsAttr(t, odsTableNS, "name")})
+				cur = &sheets[len(sheets)-1]
+				pendingRows = 0
+			case t.Name.Space == odsTableNS && t.Name.Local == "table-row":
+				row = nil
+				pendingCells = 0
+				rowRep = odsRepeat(t, "number-rows-repeated")
+			case t.Name.Space == odsTableNS && (t.Name.Local == "table-cell" || t.Name.Local == "covered-table-cell"):
+				inCell = true
+				paraSeen = false
+				cell.Reset()
+				cellRep = odsRepeat(t, "number-columns-repeated")
+				// The typed value is a fallback for cells without display text
+				cellVal = ""
+				if odsAttr(t, odsOfficeNS, "value-type") == "float" {
+					cellVal = odsAttr(t, odsOfficeNS, "value")
+				}
+			case t.Name.Space == odsTextNS && t.Name.Local == "p":
+				if inCell && paraSeen {
+					cell.WriteString("\n")
+				}
+				paraSeen = true
+			case t.Name.Space == odsTextNS && t.Name.Local == "s":
+				if inCell {
+					n := odsRepeat(t, "c")
+					cell.WriteString(strings.Repeat(" ", n))
+				}
+			}
+		case xml.CharData:
+			if inCell {
+				cell.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case t.Name.Space == odsTableNS && (t.Name.Local == "table-cell" || t.Name.Local == "covered-table-cell"):
+				inCell = false
+				if cur == nil {
+					continue
+				}
+				v := cell.String()
+				if v == "" {
+					v = cellVal
+				}
+				if v == "" {
+					pendingCells += cellRep
+					continue
+				}
+				if cells += pendingCells + cellRep; cells > maxSheetCells {
+					return nil, fmt.Errorf("sheet %q is too large", cur.Name)
+				}
+				row = append(row, make([]string, pendingCells)...)
+				pendingCells = 0
+				for i := 0; i < cellRep; i++ {
+					row = append(row, v)
+				}
+			case t.Name.Space == odsTableNS && t.Name.Local == "table-row":
+				if cur == nil {
+					continue
+				}
+				if isEmptyRow(row) {
+					pendingRows += rowRep
+					continue
+				}
+				if cells += pendingRows + rowRep*len(row); cells > maxSheetCells {
+					return nil, fmt.Errorf("sheet %q is too large", cur.Name)
+				}
+				cur.Rows = append(cur.Rows, make([][]string, pendingRows)...)
+				pendingRows = 0
+				for i := 0; i < rowRep; i++ {
+					cur.Rows = append(cur.Rows, append([]string(nil), row...))
+				}
+			case t.Name.Space == odsTableNS && t.Name.Local == "table":
+				cur = nil
+			}
+		}
+	}
+	return sheets, nil
+}
+
+func odsAttr(el xml.StartElement, space, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// odsRepeat reads a repeat-count attribute, defaulting to 1
+func odsRepeat(el xml.StartElement, local string) int {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			if n, err := strconv.Atoi(a.Value); err == nil && n > 0 {
+				// Past the cell limit the count only needs to trip it
+				return min(n, maxSheetCells+1)
+			}
+		}
+	}
+	return 1
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCellRef(t *testing.T) {
+	tests := []struct {
+		ref      string
+		col, row int
+		ok       bool
+	}{
+		{"A1", 1, 1, true},
+		{"Z9", 26, 9, true},
+		{"AA10", 27, 10, true},
+		{"AB12", 28, 12, true},
+		{"XFD1048576", 16384, 1048576, true},
+		{"XFE1", 0, 0, false},
+		{"ZZZ1", 0, 0, false},
+		{"AAAA1", 0, 0, false},
+		{"ZZZZZZZZZZZZZZ1", 0, 0, false},
+		{"A1048577", 0, 0, false},
+		{"A0", 0, 0, false},
+		{"A-3", 0, 0, false},
+		{"A99999999999999999999", 0, 0, false},
+		{"A", 0, 0, false},
+		{"12", 0, 0, false},
+		{"", 0, 0, false},
+		{"a1", 0, 0, false},
+	}
+	for _, tt := range tests {
+		col, row, err := parseCellRef(tt.ref)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseCellRef(%q) error = %v, want ok %v", tt.ref, err, tt.ok)
+			continue
+		}
+		if tt.ok && (col != tt.col || row != tt.row) {
+			t.Errorf("parseCellRef(%q) = %d, %d, want %d, %d", tt.ref, col, row, tt.col, tt.row)
+		}
+	}
+}
+
+func TestParseCellRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *cellRange
+	}{
+		{"B2:F40", &cellRange{col1: 2, row1: 2, col2: 6, row2: 40}},
+		{" b2:f40 ", &cellRange{col1: 2, row1: 2, col2: 6, row2: 40}},
+		{"F40:B2", &cellRange{col1: 2, row1: 2, col2: 6, row2: 40}},
+		{"C3", &cellRange{col1: 3, row1: 3, col2: 3, row2: 3}},
+		{"A1:XFD1048576", &cellRange{col1: 1, row1: 1, col2: 16384, row2: 1048576}},
+		{"A1:ZZZZZZZZZZZZZZ1", nil},
+		{"A1:", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseCellRange(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("parseCellRange(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || *got != *tt.want {
+			t.Errorf("parseCellRange(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplyCellRange(t *testing.T) {
+	grid := [][]string{
+		{"a1", "b1", "c1"},
+		{"a2", "b2"},
+		{"a3", "b3", "c3"},
+	}
+	tests := []struct {
+		name string
+		rng  cellRange
+		want [][]string
+		ok   bool
+	}{
+		{"inner", cellRange{2, 1, 3, 2}, [][]string{{"b1", "c1"}, {"b2", ""}}, true},
+		{"past edges", cellRange{3, 3, 4, 5}, [][]string{{"c3", ""}}, true},
+		{"below grid", cellRange{1, 4, 2, 9}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := applyCellRange(grid, tt.rng)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A tall grid makes the full-width range too large to materialize
+	tall := make([][]string, 200)
+	if _, err := applyCellRange(tall, cellRange{1, 1, maxSheetCols, maxSheetRows}); err == nil {
+		t.Error("full-width range over 200 rows: want error")
+	}
+}
+
+// writeXLSX builds a one-sheet workbook around the given sheetData rows
+func writeXLSX(t *testing.T, sheetData string) string {
+	t.Helper()
+	return writeZip(t, "book.xlsx", map[string]string{
+		"xl/workbook.xml":            `<workbook><sheets><sheet name="S1"/></sheets></workbook>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData>` + sheetData + `</sheetData></worksheet>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships/>`,
+	})
+}
+
+// writeZip stores files in a new archive and returns its path
+func writeZip(t *testing.T, name string, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return path
+}
+
+func TestParseXLSXRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name: "refs and gaps",
+			data: `<row r="1"><c r="B1" t="inlineStr"><is><t>x</t></is></c></row><row r="3"><c r="A3"><v>7</v></c></row>`,
+			want: [][]string{{"", "x"}, nil, {"7"}},
+		},
+		{
+			name: "implicit rows",
+			data: `<row><c><v>1</v></c></row><row><c><v>2</v></c></row>`,
+			want: [][]string{{"1"}, {"2"}},
+		},
+		{name: "negative row", data: `<row r="-3"><c><v>1</v></c></row>`, wantErr: "out of range"},
+		{name: "row past limit", data: `<row r="1048577"><c><v>1</v></c></row>`, wantErr: "out of range"},
+		{name: "huge column ref", data: `<row r="1"><c r="ZZZZZZZZZZZZZZ1"><v>1</v></c></row>`, want: [][]string{{"1"}}},
+	}
+	for _, tt := range tests {
+		sheets, err := parseXLSX(writeXLSX(t, tt.data))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(sheets) != 1 || !reflect.DeepEqual(sheets[0].Rows, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, sheets, tt.want)
+		}
+	}
+}
+
+func TestSpreadsheetEntryLimit(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxArchiveEntryMB = 1 })
+
+	// A megabyte of spaces compresses to a few kilobytes
+	bomb := `<sst><si><t>` + strings.Repeat(" ", 1<<20) + `</t></si></sst>`
+	path := writeZip(t, "bomb.xlsx", map[string]string{
+		"xl/workbook.xml":          `<workbook><sheets><sheet name="S1"/></sheets></workbook>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData/></worksheet>`,
+		"xl/sharedStrings.xml":     bomb,
+	})
+	if _, err := parseXLSX(path); err == nil || !strings.Contains(err.Error(), "larger than 1 MB") {
+		t.Errorf("oversized shared strings: %v", err)
+	}
+
+	path = writeZip(t, "bomb.ods", map[string]string{"content.xml": bomb})
+	if _, err := parseODS(path); err == nil || !strings.Contains(err.Error(), "larger than 1 MB") {
+		t.Errorf("oversized content.xml: %v", err)
+	}
+}
+
+func TestParseODSCellOutsideTable(t *testing.T) {
+	content := `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+		xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+		xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">
+		<table:table-row><table:table-cell table:number-columns-repeated="3000000"><text:p>x</text:p></table:table-cell></table:table-row>
+		<table:table table:name="Data"><table:table-row><table:table-cell><text:p>kept</text:p></table:table-cell></table:table-row></table:table>
+	</office:document-content>`
+	sheets, err := parseODS(writeZip(t, "stray.ods", map[string]string{"content.xml": content}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheets) != 1 || len(sheets[0].Rows) != 1 || sheets[0].Rows[0][0] != "kept" {
+		t.Errorf("sheets = %+v", sheets)
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// extractedTable is a table pulled from a Pandoc AST as a grid of strings
+type extractedTable struct {
+	Caption string
+	Rows    [][]string
+}
+
+// pandocElem is a generic Pandoc AST node ({"t": ..., "c": ...})
+type pandocElem struct {
+	T string          `json:"t"`
+	C json.RawMessage `json:"c"`
+}
+
+// readTablesFromAST parses a Pandoc JSON document and returns every table
+func readTablesFromAST(jsonPath string) ([]extractedTable, error) {
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Blocks []json.RawMessage `json:"blocks"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid pandoc JSON: %w", err)
+	}
+
+	var tables []extractedTable
+	collectTables(doc.Blocks, &tables)
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no tables found in document")
+	}
+	return tables, nil
+}
+
+// collectTables walks blocks recursively and appends each Table found
+func collectTables(blocks []json.RawMessage, tables *[]extractedTable) {
+	for _, raw := range blocks {
+		var el pandocElem
+		if json.Unmarshal(raw, &el) != nil {
+			continue
+		}
+		switch el.T {
+		case "Table":
+			if t, ok := parseTable(el.C); ok {
+				*tables = append(*tables, t)
+			}
+			// Nested tables inside cells are extracted as well
+			var parts []json.RawMessage
+			json.Unmarshal(el.C, &parts)
+			for _, p := range parts {
+				collectNestedTables(p, tables)
+			}
+		case "Div", "Figure":
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) > 0 {
+				var inner []json.RawMessage
+				json.Unmarshal(parts[len(parts)-1], &inner)
+				collectTables(inner, tables)
+			}
+		case "BlockQuote":
+			var inner []json.RawMessage
+			json.Unmarshal(el.C, &inner)
+			collectTables(inner, tables)
+		case "BulletList", "DefinitionList", "OrderedList":
+			collectNestedTables(el.C, tables)
+		}
+	}
+}
+
+// collectNestedTables searches arbitrary AST structure for block lists
+func collectNestedTables(raw json.RawMessage, tables *[]extractedTable) {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) != nil {
+		return
+	}
+	var el pandocElem
+	if len(list) > 0 && json.Unmarshal(list[0], &el) == nil && el.T != "" {
+		collectTables(list, tables)
+		return
+	}
+	for _, item := range list {
+		collectNestedTables(item, tables)
+	}
+}
+
+// parseTable converts a Table node's content into a grid, expanding
+// colspans and rowspans so every row has the same number of columns
+func parseTable(c json.RawMessage) (extractedTable, bool) {
+	var parts []json.RawMessage
+	if json.Unmarshal(c, &parts) != nil {
+		return extractedTable{}, false
+	}
+	// Pandoc < 2.10: [caption, aligns, widths, headers, rows]
+	if len(parts) == 5 {
+		return parseLegacyTable(parts)
+	}
+	if len(parts) != 6 {
+		return extractedTable{}, false
+	}
+
+	var t extractedTable
+	var caption []json.RawMessage
+	if json.Unmarshal(parts[1], &caption) == nil && len(caption) == 2 {
+		var blocks []json.RawMessage
+		json.Unmarshal(caption[1], &blocks)
+		t.Caption = blocksText(blocks)
+	}
+
+	var colSpecs []json.RawMessage
+	json.Unmarshal(parts[2], &colSpecs)
+
+	// Gather rows in document order: head, bodies (intermediate head + body), foot
+	var rows []json.RawMessage
+	rows = append(rows, sectionRows(parts[3], 1)...)
+	var bodies []json.RawMessage
+	json.Unmarshal(parts[4], &bodies)
+	for _, b := range bodies {
+		rows = append(rows, sectionRows(b, 2)...)
+		rows = append(rows, sectionRows(b, 3)...)
+	}
+	rows = append(rows, sectionRows(parts[5], 1)...)
+
+	t.Rows = layoutRows(rows, len(colSpecs))
+	return t, true
+}
+
+// sectionRows returns the row list at index i of a table section
+func sectionRows(raw json.RawMessage, i int) []json.RawMessage {
+	var section []json.RawMessage
+	if json.Unmarshal(raw, &section) != nil || len(section) <= i {
+		return nil
+	}
+	var rows []json.RawMessage
+	json.Unmarshal(section[i], &rows)
+	return rows
+}
+
+// maxTableSpan caps row and column spans; a table cannot be wider than a
+// worksheet anyway
+const maxTableSpan = maxSheetCols
+
+// layoutRows places cells into a grid honouring row and column spans
+func layoutRows(rows []json.RawMessage, width int) [][]string {
+	var grid [][]string
+	// pending[col] counts how many more rows a rowspan above still covers
+	var pending []int
+
+	for _, raw := range rows {
+		var row []json.RawMessage
+		if json.Unmarshal(raw, &row) != nil || len(row) != 2 {
+			continue
+		}
+		var cells []json.RawMessage
+		json.Unmarshal(row[1], &cells)
+
+		var out []string
+		col := 0
+		skipCovered := func() {
+			for col < len(pending) && pending[col] > 0 {
+				pending[col]--
+				out = append(out, "")
+				col++
+			}
+		}
+		for _, rawCell := range cells {
+			// Cell: [attr, alignment, rowspan, colspan, blocks]
+			var cell []json.RawMessage
+			if json.Unmarshal(rawCell, &cell) != nil || len(cell) != 5 {
+				continue
+			}
+			skipCovered()
+			if col >= maxTableSpan {
+				break
+			}
+			var rowSpan, colSpan int
+			json.Unmarshal(cell[2], &rowSpan)
+			json.Unmarshal(cell[3], &colSpan)
+			rowSpan = min(max(rowSpan, 1), maxTableSpan)
+			colSpan = min(max(colSpan, 1), maxTableSpan-col)
+
+			var blocks []json.RawMessage
+			json.Unmarshal(cell[4], &blocks)
+			out = append(out, blocksText(blocks))
+			for i := 1; i < colSpan; i++ {
+				out = append(out, "")
+			}
+			for i := 0; i < colSpan; i++ {
+				for len(pending) <= col {
+					pending = append(pending, 0)
+				}
+				pending[col] = rowSpan - 1
+				col++
+			}
+		}
+		// Rowspans to the right of the last cell still occupy their columns
+		for c := col; c < len(pending); c++ {
+			if pending[c] > 0 {
+				pending[c]--
+				for len(out) <= c {
+					out = append(out, "")
+				}
+			}
+		}
+		grid = append(grid, out)
+	}
+
+	for _, row := range grid {
+		width = max(width, len(row))
+	}
+	for i, row := range grid {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		grid[i] = row
+	}
+	return grid
+}
+
+// parseLegacyTable handles the pre-2.10 Table representation
+func parseLegacyTable(parts []json.RawMessage) (extractedTable, bool) {
+	var t extractedTable
+	var caption []json.RawMessage
+	json.Unmarshal(parts[0], &caption)
+	t.Caption = inlinesText(caption)
+
+	var header [][]json.RawMessage
+	json.Unmarshal(parts[3], &header)
+	var body [][][]json.RawMessage
+	json.Unmarshal(parts[4], &body)
+
+	toRow := func(cells [][]json.RawMessage) []string {
+		row := make([]string, len(cells))
+		for i, blocks := range cells {
+			row[i] = blocksText(blocks)
+		}
+		return row
+	}
+
+	headerRow := toRow(header)
+	if !isEmptyRow(headerRow) {
+		t.Rows = append(t.Rows, headerRow)
+	}
+	for _, r := range body {
+		t.Rows = append(t.Rows, toRow(r))
+	}
+	return t, true
+}
+
+// blocksText flattens block content to plain text, one line per block
+func blocksText(blocks []json.RawMessage) string {
+	var lines []string
+	for _, raw := range blocks {
+		var el pandocElem
+		if json.Unmarshal(raw, &el) != nil {
+			continue
+		}
+		var text string
+		switch el.T {
+		case "Plain", "Para":
+			var inlines []json.RawMessage
+			json.Unmarshal(el.C, &inlines)
+			text = inlinesText(inlines)
+		case "Header":
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) == 3 {
+				var inlines []json.RawMessage
+				json.Unmarshal(parts[2], &inlines)
+				text = inlinesText(inlines)
+			}
+		case "CodeBlock":
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) == 2 {
+				json.Unmarshal(parts[1], &text)
+			}
+		case "LineBlock":
+			var lineList [][]json.RawMessage
+			json.Unmarshal(el.C, &lineList)
+			var ls []string
+			for _, l := range lineList {
+				ls = append(ls, inlinesText(l))
+			}
+			text = strings.Join(ls, "\n")
+		case "BlockQuote":
+			var inner []json.RawMessage
+			json.Unmarshal(el.C, &inner)
+			text = blocksText(inner)
+		case "Div":
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) == 2 {
+				var inner []json.RawMessage
+				json.Unmarshal(parts[1], &inner)
+				text = blocksText(inner)
+			}
+		case "BulletList":
+			var items [][]json.RawMessage
+			json.Unmarshal(el.C, &items)
+			var ls []string
+			for _, item := range items {
+				ls = append(ls, blocksText(item))
+			}
+			text = strings.Join(ls, "\n")
+		case "OrderedList":
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) == 2 {
+				var items [][]json.RawMessage
+				json.Unmarshal(parts[1], &items)
+				var ls []string
+				for _, item := range items {
+					ls = append(ls, blocksText(item))
+				}
+				text = strings.Join(ls, "\n")
+			}
+		}
+		if text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// inlinesText flattens inline content to plain text
+func inlinesText(inlines []json.RawMessage) string {
+	var b strings.Builder
+	for _, raw := range inlines {
+		var el pandocElem
+		if json.Unmarshal(raw, &el) != nil {
+			continue
+		}
+		switch el.T {
+		case "Str":
+			var s string
+			json.Unmarshal(el.C, &s)
+			b.WriteString(s)
+		case "Space", "SoftBreak":
+			b.WriteString(" ")
+		case "LineBreak":
+			b.WriteString("\n")
+		case "Emph", "Strong", "Strikeout", "Superscript", "Subscript", "SmallCaps", "Underline":
+			var inner []json.RawMessage
+			json.Unmarshal(el.C, &inner)
+			b.WriteString(inlinesText(inner))
+		case "Quoted":
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) == 2 {
+				var inner []json.RawMessage
+				json.Unmarshal(parts[1], &inner)
+				b.WriteString("“" + inlinesText(inner) + "”")
+			}
+		case "Code", "Math":
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) == 2 {
+				var s string
+				json.Unmarshal(parts[1], &s)
+				b.WriteString(s)
+			}
+		case "Link", "Image", "Span", "Cite":
+			// Visible content is the second element
+			var parts []json.RawMessage
+			if json.Unmarshal(el.C, &parts) == nil && len(parts) >= 2 {
+				var inner []json.RawMessage
+				json.Unmarshal(parts[1], &inner)
+				b.WriteString(inlinesText(inner))
+			}
+		}
+	}
+	return b.String()
+}
+
+// writeTablesDelimited writes each table as a CSV or TSV entry of a ZIP
+func writeTablesDelimited(tables []extractedTable, outPath string, comma rune, ext string) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for i, t := range tables {
+		w, err := zw.Create(fmt.Sprintf("table_%d%s", i+1, ext))
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(w)
+		cw.Comma = comma
+		if err := cw.WriteAll(t.Rows); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeTablesXLSX writes a workbook with one sheet per table
+func writeTablesXLSX(tables []extractedTable, outPath string) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names := make([]string, len(tables))
+	used := make(map[string]bool)
+	for i, t := range tables {
+		names[i] = uniqueSheetName(t.Caption, i+1, used)
+	}
+
+	zw := zip.NewWriter(f)
+	add := func(name, content string) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, content)
+		return err
+	}
+
+	var contentTypes, sheets, rels strings.Builder
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	rels.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range names {
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(name), i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(names)+1)
+	contentTypes.WriteString(`</Types>`)
+	rels.WriteString(`</Relationships>`)
+
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font/><font><b/></font></fonts>` +
+			`<fills count="1"><fill/></fills><borders count="1"><border/></borders>` +
+			`<cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf/><xf fontId="1" applyFont="1"/></cellXfs>` +
+			`</styleSheet>`},
+	}
+	for _, file := range files {
+		if err := add(file.name, file.content); err != nil {
+			return err
+		}
+	}
+	for i, t := range tables {
+		if err := add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxSheetXML(t.Rows)); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// xlsxSheetXML renders rows as worksheet XML; the first row is bold and
+// numeric cells are stored as numbers
+func xlsxSheetXML(rows [][]string) string {
+	var b strings.Builder
+	b.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for r, row := range rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+		for c, v := range row {
+			ref := columnName(c+1) + strconv.Itoa(r+1)
+			style := ""
+			if r == 0 {
+				style = ` s="1"`
+			}
+			if isXLSXNumber(v) {
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%s</v></c>`, ref, style, v)
+			} else if v != "" {
+				fmt.Fprintf(&b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlEscape(v))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// isXLSXNumber reports whether a cell can be stored as a number: a finite
+// decimal, not NaN, Inf or the hex and underscore forms ParseFloat accepts.
+// Leading zeros ("00123") mark codes such as ZIP or part numbers, which
+// would lose them as numbers.
+func isXLSXNumber(v string) bool {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+	if strings.Trim(v, "0123456789.eE+-") != "" {
+		return false
+	}
+	digits := strings.TrimLeft(v, "+-")
+	return !(len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9')
+}
+
+// columnName converts a 1-based column number to letters (1 → A, 27 → AA)
+func columnName(n int) string {
+	var name []byte
+	for n > 0 {
+		n--
+		name = append([]byte{byte('A' + n%26)}, name...)
+		n /= 26
+	}
+	return string(name)
+}
+
+// uniqueSheetName derives a valid, unique Excel sheet name
+func uniqueSheetName(caption string, n int, used map[string]bool) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(caption))
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	for k := n; name == "" || used[strings.ToLower(name)]; k++ {
+		name = fmt.Sprintf("Table %d", k)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
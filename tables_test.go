@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsXLSXNumber(t *testing.T) {
+	tests := map[string]bool{
+		"42":       true,
+		"-3.5":     true,
+		"1e6":      true,
+		"0.25":     true,
+		"NaN":      false,
+		"nan":      false,
+		"Inf":      false,
+		"-Inf":     false,
+		"Infinity": false,
+		"1e999":    false,
+		"0x1p4":    false,
+		"1_000":    false,
+		" 42":      false,
+		"":         false,
+		"12 kg":    false,
+		"0":        true,
+		"0.5":      true,
+		"-0.5":     true,
+		"0e3":      true,
+		"10":       true,
+		"00123":    false,
+		"007":      false,
+		"-007":     false,
+		"00.5":     false,
+	}
+	for in, want := range tests {
+		if got := isXLSXNumber(in); got != want {
+			t.Errorf("isXLSXNumber(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestXLSXSheetXMLNonFinite(t *testing.T) {
+	out := xlsxSheetXML([][]string{{"h"}, {"NaN"}, {"Infinity"}, {"7"}})
+	for _, bad := range []string{"<v>NaN</v>", "<v>Infinity</v>"} {
+		if strings.Contains(out, bad) {
+			t.Errorf("non-finite value written as a number: %s", bad)
+		}
+	}
+	if !strings.Contains(out, `<c r="A4"><v>7</v></c>`) {
+		t.Errorf("number not written as a numeric cell:\n%s", out)
+	}
+}
+
+// tableRow builds a Pandoc AST row of [rowspan, colspan, text] cells
+func tableRow(cells ...[3]any) json.RawMessage {
+	var cs []string
+	for _, c := range cells {
+		cs = append(cs, fmt.Sprintf(`[["",[],[]],{"t":"AlignDefault"},%v,%v,[{"t":"Plain","c":[{"t":"Str","c":%q}]}]]`, c[0], c[1], c[2]))
+	}
+	return json.RawMessage(`[["",[],[]],[` + strings.Join(cs, ",") + `]]`)
+}
+
+func TestLayoutRows(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []json.RawMessage
+		want [][]string
+	}{
+		{
+			name: "plain",
+			rows: []json.RawMessage{tableRow([3]any{1, 1, "a"}, [3]any{1, 1, "b"})},
+			want: [][]string{{"a", "b"}},
+		},
+		{
+			name: "colspan",
+			rows: []json.RawMessage{
+				tableRow([3]any{1, 2, "a"}, [3]any{1, 1, "b"}),
+				tableRow([3]any{1, 1, "c"}, [3]any{1, 1, "d"}, [3]any{1, 1, "e"}),
+			},
+			want: [][]string{{"a", "", "b"}, {"c", "d", "e"}},
+		},
+		{
+			name: "rowspan",
+			rows: []json.RawMessage{
+				tableRow([3]any{2, 1, "a"}, [3]any{1, 1, "b"}),
+				tableRow([3]any{1, 1, "c"}),
+			},
+			want: [][]string{{"a", "b"}, {"", "c"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := layoutRows(tt.rows, 0); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLayoutRowsCapsSpans(t *testing.T) {
+	rows := []json.RawMessage{
+		tableRow([3]any{100000000, 100000000, "a"}, [3]any{1, 1, "b"}),
+		tableRow([3]any{1, 1, "c"}),
+	}
+	grid := layoutRows(rows, 0)
+	if len(grid) != 2 {
+		t.Fatalf("got %d rows, want 2", len(grid))
+	}
+	for i, row := range grid {
+		if len(row) != maxTableSpan {
+			t.Errorf("row %d has %d columns, want %d", i, len(row), maxTableSpan)
+		}
+	}
+}
+
+func TestUniqueSheetName(t *testing.T) {
+	used := make(map[string]bool)
+	names := []string{
+		uniqueSheetName("Table 2", 1, used),
+		uniqueSheetName("", 2, used),
+		uniqueSheetName("Sales: Q1/Q2", 3, used),
+		uniqueSheetName("sales_ q1_q2", 4, used),
+		uniqueSheetName(strings.Repeat("x", 40), 5, used),
+	}
+	want := []string{"Table 2", "Table 3", "Sales_ Q1_Q2", "Table 4", strings.Repeat("x", 31)}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{1: "A", 26: "Z", 27: "AA", 52: "AZ", 703: "AAA", 16384: "XFD"}
+	for n, want := range tests {
+		if got := columnName(n); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
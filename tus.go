@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Resumable uploads follow the tus 1.0.0 protocol (https://tus.io) with
+// the creation, expiration and termination extensions. Each upload is
+// written to <work_dir>/<upload id>/data and, once complete, can be used
+// as the input of a conversion by passing its ID as upload_id. Creating an
+// upload returns an owner token in X-Owner-Token that every later request
+// on it, and the conversion claiming it, must send as a Bearer token. The
+// data is encrypted under a key kept with the upload in memory.
+
+const tusVersion = "1.0.0"
+
+// tusHeaders are the protocol headers browsers must be allowed to send
+// and read across origins
+var tusHeaders = []string{
+	"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+	"Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Expires",
+}
+
+// Upload is a resumable upload in progress or awaiting its job
+type Upload struct {
+	patch     sync.Mutex // held while a PATCH writes data
+	Length    int64
+	Offset    int64
+	Metadata  string // Upload-Metadata as sent by the client
+	Filename  string
+	Expires   time.Time
+	OwnerHash [sha256.Size]byte // hash of the owner token
+	key, iv   []byte            // AES-CTR key and initial counter of the data
+}
+
+// UploadStore holds uploads in memory; fields of an Upload are read and
+// written under its lock
+type UploadStore struct {
+	sync.RWMutex
+	uploads map[string]*Upload
+}
+
+var uploadStore = UploadStore{uploads: make(map[string]*Upload)}
+
+// cipherKey returns copies of the upload's key and IV, or nil once the
+// upload has been removed and its key shredded. The caller holds the
+// store's lock and shreds the copies when done.
+func (u *Upload) cipherKey(id string) (key, iv []byte) {
+	if uploadStore.uploads[id] != u {
+		return nil, nil
+	}
+	return append([]byte(nil), u.key...), append([]byte(nil), u.iv...)
+}
+
+// uploadDataPath returns the file holding an upload's bytes
+func uploadDataPath(id string) string {
+	return filepath.Join(jobDirPath(id), "data")
+}
+
+// handleUploads serves upload creation on /api/uploads and the upload
+// resources at /api/uploads/<id>
+func handleUploads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadLimit(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/uploads"), "/")
+	if id == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		createUpload(w, r)
+		return
+	}
+
+	uploadStore.RLock()
+	u := uploadStore.uploads[id]
+	uploadStore.RUnlock()
+	if u == nil || !ownerMatches(u.OwnerHash, r) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		uploadStore.RLock()
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+		if u.Metadata != "" {
+			w.Header().Set("Upload-Metadata", u.Metadata)
+		}
+		w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+		uploadStore.RUnlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		patchUpload(w, r, id, u)
+	case http.MethodDelete:
+		removeUpload(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createUpload reserves an upload of the announced length
+func createUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if limit := maxUploadLimit(); length > limit {
+		writeTooLarge(w, &tooLargeError{limit: limit})
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !enforceDiskQuota() {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Server storage is full, try again later", http.StatusInsufficientStorage)
+		return
+	}
+
+	id := uuid.New().String()
+	if _, err := createJobDir(id); err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	f, err := os.OpenFile(uploadDataPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		removeJobDir(id)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	ownerToken, ownerHash := newOwnerToken()
+	u := &Upload{
+		OwnerHash: ownerHash,
+		Length:    length,
+		Metadata:  r.Header.Get("Upload-Metadata"),
+		Filename:  uploadFilename(meta["filename"]),
+		Expires:   time.Now().Add(cfg().UploadExpiry.Std()),
+		key:       newJobKey(),
+		iv:        newJobKey()[:aes.BlockSize],
+	}
+	uploadStore.Lock()
+	uploadStore.uploads[id] = u
+	uploadStore.Unlock()
+
+	w.Header().Set("Location", "/api/uploads/"+id)
+	w.Header().Set("X-Owner-Token", ownerToken)
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// patchUpload appends a chunk at the offset the client claims to resume
+// from. Bytes received before a dropped connection are kept, so the
+// client can resume from the offset a later HEAD reports.
+func patchUpload(w http.ResponseWriter, r *http.Request, id string, u *Upload) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Missing or invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	if !u.patch.TryLock() {
+		http.Error(w, "Upload is being written by another request", http.StatusLocked)
+		return
+	}
+	defer u.patch.Unlock()
+
+	uploadStore.RLock()
+	current, remaining := u.Offset, u.Length-u.Offset
+	key, iv := u.cipherKey(id)
+	uploadStore.RUnlock()
+	if key == nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	defer shredKey(key)
+	if offset != current {
+		http.Error(w, fmt.Sprintf("Upload-Offset %d does not match the upload's offset %d", offset, current), http.StatusConflict)
+		return
+	}
+	if r.ContentLength > remaining {
+		http.Error(w, "Chunk extends past Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.OpenFile(uploadDataPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		http.Error(w, "Failed to write upload", http.StatusInternalServerError)
+		return
+	}
+	stream, err := uploadStream(key, iv, offset)
+	if err != nil {
+		http.Error(w, "Failed to write upload", http.StatusInternalServerError)
+		return
+	}
+	n, copyErr := io.Copy(cipher.StreamWriter{S: stream, W: f}, io.LimitReader(r.Body, remaining))
+
+	uploadStore.Lock()
+	u.Offset += n
+	u.Expires = time.Now().Add(cfg().UploadExpiry.Std())
+	newOffset, expires := u.Offset, u.Expires
+	uploadStore.Unlock()
+
+	if copyErr != nil {
+		log.Printf("Upload %s interrupted at offset %d: %v", id, newOffset, copyErr)
+		http.Error(w, "Upload interrupted", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("value of %q is not base64", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+// claimUpload re-encrypts a completed upload under key into a job
+// directory as its input, provided r carries the upload's owner token.
+// The upload stays available until removeUpload, so a rejected job does
+// not cost the client its upload.
+func claimUpload(id string, r *http.Request, dir string, key []byte) (*upload, error) {
+	uploadStore.RLock()
+	u := uploadStore.uploads[id]
+	if u != nil && !ownerMatches(u.OwnerHash, r) {
+		u = nil
+	}
+	var complete bool
+	var filename string
+	var length int64
+	var uploadKey, iv []byte
+	if u != nil {
+		complete, filename, length = u.Offset == u.Length, u.Filename, u.Length
+		uploadKey, iv = u.cipherKey(id)
+	}
+	uploadStore.RUnlock()
+	if u == nil {
+		return nil, errors.New("unknown or expired upload")
+	}
+	defer shredKey(uploadKey)
+	if !complete {
+		return nil, errors.New("upload is incomplete")
+	}
+
+	f, err := os.Open(uploadDataPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stream, err := uploadStream(uploadKey, iv, 0)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "input"+filepath.Ext(filename))
+	src := cipher.StreamReader{S: stream, R: io.LimitReader(f, length)}
+	if err := encryptFrom(src, path, key); err != nil {
+		return nil, err
+	}
+	return &upload{Path: path, Filename: filename, Size: length}, nil
+}
+
+// removeUpload forgets an upload and deletes its data
+func removeUpload(id string) {
+	uploadStore.Lock()
+	u, ok := uploadStore.uploads[id]
+	if ok {
+		shredKey(u.key)
+	}
+	delete(uploadStore.uploads, id)
+	uploadStore.Unlock()
+	if ok {
+		removeJobDir(id)
+	}
+}
+
+// cleanupExpiredUploads removes uploads that were not finished or used
+// before they expired
+func cleanupExpiredUploads() {
+	uploadStore.Lock()
+	now := time.Now()
+	var expired []string
+	for id, u := range uploadStore.uploads {
+		if now.After(u.Expires) {
+			shredKey(u.key)
+			delete(uploadStore.uploads, id)
+			expired = append(expired, id)
+		}
+	}
+	uploadStore.Unlock()
+
+	for _, id := range expired {
+		removeJobDir(id)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUploadCipherKeyCopies(t *testing.T) {
+	withConfig(t, func(c *Config) { c.WorkDir = t.TempDir() })
+	id := "cipher-key-upload"
+	if _, err := createJobDir(id); err != nil {
+		t.Fatal(err)
+	}
+	u := &Upload{key: newJobKey(), iv: newJobKey()[:16]}
+	want := append([]byte(nil), u.key...)
+	uploadStore.Lock()
+	uploadStore.uploads[id] = u
+	uploadStore.Unlock()
+
+	uploadStore.RLock()
+	key, iv := u.cipherKey(id)
+	uploadStore.RUnlock()
+
+	// A DELETE or expiry mid-claim must not zero the claimer's copy
+	removeUpload(id)
+	if !bytes.Equal(key, want) || len(iv) != 16 {
+		t.Error("removing the upload changed the copied key")
+	}
+	uploadStore.RLock()
+	key, _ = u.cipherKey(id)
+	uploadStore.RUnlock()
+	if key != nil {
+		t.Error("cipherKey returned a key for a removed upload")
+	}
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	got, err := parseUploadMetadata("filename " + b64("résumé.docx") + ", filetype " + b64("application/pdf") + ",is_confidential,")
+	want := map[string]string{"filename": "résumé.docx", "filetype": "application/pdf", "is_confidential": ""}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseUploadMetadata = %v, %v; want %v", got, err, want)
+	}
+	if got, err := parseUploadMetadata(""); err != nil || len(got) != 0 {
+		t.Errorf("empty metadata = %v, %v", got, err)
+	}
+	if _, err := parseUploadMetadata("filename not*base64"); err == nil {
+		t.Error("invalid base64 accepted")
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	withConfig(t, func(c *Config) { c.WorkDir = t.TempDir() })
+	const content = "# Resumed\n\nThe second half arrived later."
+
+	send := func(method, target, token string, header map[string]string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Tus-Resumable", tusVersion)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handleUploads(w, r)
+		return w
+	}
+	patch := func(location, token string, offset int, chunk string) *httptest.ResponseRecorder {
+		return send("PATCH", location, token, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}, chunk)
+	}
+
+	w := send("POST", "/api/uploads", "", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(content)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("../notes.md")),
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d", w.Code)
+	}
+	location, token := w.Header().Get("Location"), w.Header().Get("X-Owner-Token")
+	id := strings.TrimPrefix(location, "/api/uploads/")
+	t.Cleanup(func() { removeUpload(id) })
+
+	half := len(content) / 2
+	if w := patch(location, token, 0, content[:half]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first chunk: status %d, offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := patch(location, token, 0, content[:half]); w.Code != http.StatusConflict {
+		t.Errorf("stale offset: status %d, want 409", w.Code)
+	}
+	if w := patch(location, token, half, content[half:]+"extra"); w.Code != http.StatusBadRequest {
+		t.Errorf("chunk past Upload-Length: status %d, want 400", w.Code)
+	}
+	if w := send("PATCH", location, token, map[string]string{"Upload-Offset": strconv.Itoa(half)}, content[half:]); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong Content-Type: status %d, want 415", w.Code)
+	}
+
+	r := httptest.NewRequest("POST", "/api/convert", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if _, err := claimUpload(id, r, t.TempDir(), newJobKey()); err == nil {
+		t.Error("incomplete upload claimed")
+	}
+
+	if w := send("HEAD", location, token, nil, ""); w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("HEAD offset %s, want %d", w.Header().Get("Upload-Offset"), half)
+	}
+	if w := patch(location, token, half, content[half:]); w.Code != http.StatusNoContent {
+		t.Fatalf("second chunk: status %d", w.Code)
+	}
+
+	key := newJobKey()
+	up, err := claimUpload(id, r, t.TempDir(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Filename != "notes.md" || up.Size != int64(len(content)) || readStored(t, up.Path, key) != content {
+		t.Errorf("claimed upload %+v does not hold the uploaded bytes", up)
+	}
+
+	if w := send("DELETE", location, token, nil, ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: status %d", w.Code)
+	}
+	if w := send("HEAD", location, token, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after DELETE: status %d, want 404", w.Code)
+	}
+}
+
+func TestResumableUploadWithoutFilename(t *testing.T) {
+	withConfig(t, func(c *Config) { c.WorkDir = t.TempDir() })
+	const content = "no name"
+
+	r := httptest.NewRequest("POST", "/api/uploads", nil)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	w := httptest.NewRecorder()
+	handleUploads(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d", w.Code)
+	}
+	location, token := w.Header().Get("Location"), w.Header().Get("X-Owner-Token")
+	id := strings.TrimPrefix(location, "/api/uploads/")
+	t.Cleanup(func() { removeUpload(id) })
+
+	r = httptest.NewRequest("PATCH", location, strings.NewReader(content))
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.Header.Set("Upload-Offset", "0")
+	w = httptest.NewRecorder()
+	handleUploads(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("patch: status %d", w.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/api/convert", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	up, err := claimUpload(id, r, t.TempDir(), newJobKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Filename != "upload" {
+		t.Errorf("Filename = %q, want upload", up.Filename)
+	}
+}
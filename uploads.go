@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// uploadOverhead allows for multipart boundaries, part headers and form
+// fields on top of the input itself
+const uploadOverhead = 1 << 20
+
+// maxFormValue bounds each non-file field of a multipart upload
+const maxFormValue = 64 << 10
+
+// uploadLimit returns the maximum input size in bytes for a source format
+func uploadLimit(format string) int64 {
+	c := cfg()
+	if mb, ok := c.FormatLimitsMB[format]; ok {
+		return int64(mb) << 20
+	}
+	return int64(c.MaxUploadMB) << 20
+}
+
+// maxUploadLimit is the largest input any source format accepts
+func maxUploadLimit() int64 {
+	return cfg().maxUploadBytes()
+}
+
+// tooLargeError reports an input over its size limit
+type tooLargeError struct {
+	format string // empty when the format was not known yet
+	limit  int64
+}
+
+func (e *tooLargeError) Error() string {
+	if e.format == "" {
+		return fmt.Sprintf("Upload exceeds the %d MB limit", e.limit>>20)
+	}
+	return fmt.Sprintf("Upload exceeds the %d MB limit for %s input", e.limit>>20, e.format)
+}
+
+// writeTooLarge sends a 413 with the limit that was exceeded
+func writeTooLarge(w http.ResponseWriter, e *tooLargeError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      e.Error(),
+		"error_info": newConversionError(ErrCodeTooLarge, e.Error()),
+		"max_bytes":  e.limit,
+	})
+}
+
+// asTooLarge converts the error of a body read past http.MaxBytesReader's
+// limit into a tooLargeError
+func asTooLarge(err error) (*tooLargeError, bool) {
+	var tl *tooLargeError
+	if errors.As(err, &tl) {
+		return tl, true
+	}
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return &tooLargeError{limit: maxUploadLimit()}, true
+	}
+	return nil, false
+}
+
+// upload is a file saved from a multipart request
+type upload struct {
+	Path     string
+	Filename string
+	Size     int64
+}
+
+// readMultipartUpload streams a multipart form without buffering it: the
+// "file" part is encrypted under key to dir as input<ext> and the other fields are
+// returned along with the query parameters. The file is cut off at the
+// limit of the "from" field when that precedes it, else at the largest
+// limit; callers check the final size once the format is known.
+func readMultipartUpload(r *http.Request, dir string, key []byte) (url.Values, *upload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	form := r.URL.Query()
+	var up *upload
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := part.FormName()
+		if name == "file" && up == nil {
+			up, err = saveUpload(part, dir, part.FileName(), form.Get("from"), key)
+			part.Close()
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormValue+1))
+		part.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(value) > maxFormValue {
+			return nil, nil, fmt.Errorf("form field %q is too large", name)
+		}
+		if name != "" {
+			form.Set(name, string(value))
+		}
+	}
+	return form, up, nil
+}
+
+// saveUpload encrypts an uploaded file to disk under key, stopping past the
+// limit of format, or the largest limit when the format is not known yet
+func saveUpload(src io.Reader, dir, filename, format string, key []byte) (*upload, error) {
+	limit := maxUploadLimit()
+	if format != "" {
+		limit = uploadLimit(format)
+	}
+	path := filepath.Join(dir, "input"+filepath.Ext(filename))
+	w, err := createEncrypted(path, key)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(w, io.LimitReader(src, limit+1))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, &tooLargeError{format: format, limit: limit}
+	}
+	return &upload{Path: path, Filename: filename, Size: n}, nil
+}
+
+// extensionMediaTypes gives the media type of each file extension, used
+// for the Content-Type of outputs and to recognise raw request bodies
+var extensionMediaTypes = map[string]string{
+	".md":      "text/markdown",
+	".html":    "text/html",
+	".txt":     "text/plain",
+	".rst":     "text/x-rst",
+	".tex":     "application/x-tex",
+	".org":     "text/org",
+	".adoc":    "text/asciidoc",
+	".csv":     "text/csv",
+	".tsv":     "text/tab-separated-values",
+	".rtf":     "application/rtf",
+	".textile": "text/x-textile",
+	".opml":    "text/x-opml",
+	".xml":     "application/docbook+xml",
+	".json":    "application/json",
+	".ipynb":   "application/x-ipynb+json",
+	".fb2":     "application/x-fictionbook+xml",
+	".zip":     "application/zip",
+	".pdf":     "application/pdf",
+	".epub":    "application/epub+zip",
+	".docx":    "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".pptx":    "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".xlsx":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".odt":     "application/vnd.oasis.opendocument.text",
+	".ods":     "application/vnd.oasis.opendocument.spreadsheet",
+}
+
+// mediaTypeExtensions maps media types of raw request bodies to the file
+// extensions in extensionFormats: those of extensionMediaTypes and other
+// names clients use. Types not listed are looked up in the system's MIME
+// table.
+var mediaTypeExtensions = func() map[string]string {
+	m := map[string]string{
+		"text/x-markdown":       ".md",
+		"application/xhtml+xml": ".html",
+		"text/x-tex":            ".tex",
+		"application/x-latex":   ".tex",
+		"text/x-org":            ".org",
+		"text/x-asciidoc":       ".adoc",
+		"text/rtf":              ".rtf",
+		"text/x-opml+xml":       ".opml",
+	}
+	for ext, mediaType := range extensionMediaTypes {
+		m[mediaType] = ext
+	}
+	return m
+}()
+
+// mediaTypeFormat returns the pandoc format of a media type, or "" when
+// it names none
+func mediaTypeFormat(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	if ext, ok := mediaTypeExtensions[mediaType]; ok {
+		return extensionFormats[ext]
+	}
+	exts, _ := mime.ExtensionsByType(mediaType)
+	for _, ext := range exts {
+		if format, ok := extensionFormats[ext]; ok {
+			return format
+		}
+	}
+	return ""
+}
+
+// isRawRequest reports whether a request body is the document itself
+// rather than a JSON or form request. PUT bodies always are; POST bodies
+// are when the media type names a binary format. Text types need raw=true,
+// since clients post JSON as text/plain to avoid a CORS preflight.
+func isRawRequest(r *http.Request, mediaType string) bool {
+	if r.Method == http.MethodPut || r.URL.Query().Get("raw") == "true" {
+		return true
+	}
+	return mediaType == "application/octet-stream" || binaryFormats[mediaTypeFormat(mediaType)]
+}
+
+// acceptFormat picks the output format from an Accept header: the most
+// preferred media type that names a format. JSON and wildcards describe
+// the API response, not the document, and are skipped.
+func acceptFormat(accept string) string {
+	best, bestQ := "", 0.0
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil || mediaType == "application/json" || strings.Contains(mediaType, "*") {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if format := mediaTypeFormat(mediaType); format != "" && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// uploadFilename returns the last element of a client-supplied file name,
+// or "upload" when the name is empty or has no usable last element
+func uploadFilename(name string) string {
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "upload"
+	}
+	return name
+}
+
+// saveRawUpload streams a raw request body to dir as input<ext>. The
+// upload has the Content-Disposition filename when the client sent one.
+func saveRawUpload(r *http.Request, dir, format string, key []byte) (*upload, error) {
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return saveUpload(r.Body, dir, uploadFilename(params["filename"]), format, key)
+	}
+	up, err := saveUpload(r.Body, dir, "upload"+formatExtensions[format], format, key)
+	if up != nil {
+		up.Filename = ""
+	}
+	return up, err
+}
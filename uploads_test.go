@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsRawRequest(t *testing.T) {
+	tests := []struct {
+		method, target, mediaType string
+		want                      bool
+	}{
+		{"POST", "/api/convert", "application/json", false},
+		{"POST", "/api/convert", "text/plain", false},
+		{"POST", "/api/convert", "text/markdown", false},
+		{"POST", "/api/convert", "text/html", false},
+		{"POST", "/api/convert", "", false},
+		{"POST", "/api/convert", "application/octet-stream", true},
+		{"POST", "/api/convert", "application/pdf", true},
+		{"POST", "/api/convert", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", true},
+		{"POST", "/api/convert?raw=true", "text/markdown", true},
+		{"PUT", "/api/convert", "text/plain", true},
+		{"PUT", "/api/convert", "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if got := isRawRequest(r, tt.mediaType); got != tt.want {
+			t.Errorf("%s %s with %q: isRawRequest = %v, want %v", tt.method, tt.target, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestMediaTypeFormat(t *testing.T) {
+	tests := map[string]string{
+		"text/markdown":         "markdown",
+		"TEXT/HTML":             "html",
+		"application/pdf":       "pdf",
+		"application/epub+zip":  "epub",
+		"application/x-unknown": "",
+	}
+	for mediaType, want := range tests {
+		if got := mediaTypeFormat(mediaType); got != want {
+			t.Errorf("mediaTypeFormat(%q) = %q, want %q", mediaType, got, want)
+		}
+	}
+}
+
+func TestAcceptFormat(t *testing.T) {
+	tests := map[string]string{
+		"":                                       "",
+		"application/json":                       "",
+		"*/*":                                    "",
+		"text/html":                              "html",
+		"application/json, application/pdf":      "pdf",
+		"text/html;q=0.5, application/pdf":       "pdf",
+		"text/html;q=0.9, application/pdf;q=0.4": "html",
+		"text/html;q=bad, text/markdown":         "markdown",
+	}
+	for accept, want := range tests {
+		if got := acceptFormat(accept); got != want {
+			t.Errorf("acceptFormat(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func TestUploadLimit(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.MaxUploadMB = 2
+		c.FormatLimitsMB = map[string]int{"pdf": 5}
+	})
+	if got := uploadLimit("pdf"); got != 5<<20 {
+		t.Errorf("uploadLimit(pdf) = %d, want %d", got, 5<<20)
+	}
+	if got := uploadLimit("markdown"); got != 2<<20 {
+		t.Errorf("uploadLimit(markdown) = %d, want %d", got, 2<<20)
+	}
+	if got := maxUploadLimit(); got != 5<<20 {
+		t.Errorf("maxUploadLimit = %d, want %d", got, 5<<20)
+	}
+}
+
+// readStored decrypts a saved upload
+func readStored(t *testing.T, path string, key []byte) string {
+	t.Helper()
+	d, err := openEncrypted(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	data, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSaveUploadLimit(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.MaxUploadMB = 1
+		c.FormatLimitsMB = map[string]int{"pdf": 2}
+	})
+	dir, key := t.TempDir(), newJobKey()
+
+	exact := strings.Repeat("a", 1<<20)
+	up, err := saveUpload(strings.NewReader(exact), dir, "notes.md", "markdown", key)
+	if err != nil {
+		t.Fatalf("upload at the limit: %v", err)
+	}
+	if up.Size != 1<<20 || readStored(t, up.Path, key) != exact {
+		t.Errorf("upload at the limit saved %d bytes", up.Size)
+	}
+
+	_, err = saveUpload(strings.NewReader(exact+"a"), t.TempDir(), "notes.md", "markdown", key)
+	var tl *tooLargeError
+	if !errors.As(err, &tl) || tl.limit != 1<<20 || tl.format != "markdown" {
+		t.Errorf("upload over the limit: %v", err)
+	}
+
+	// An unknown format is held to the largest limit
+	if _, err := saveUpload(strings.NewReader(exact+"a"), t.TempDir(), "scan.pdf", "", key); err != nil {
+		t.Errorf("upload of unknown format under the largest limit: %v", err)
+	}
+}
+
+func TestReadMultipartUpload(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.MaxUploadMB = 1
+		c.FormatLimitsMB = nil
+	})
+
+	build := func(fields [][2]string, file string) *http.Request {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, f := range fields {
+			mw.WriteField(f[0], f[1])
+		}
+		fw, _ := mw.CreateFormFile("file", "doc.md")
+		fw.Write([]byte(file))
+		mw.WriteField("to", "html")
+		mw.Close()
+		r := httptest.NewRequest("POST", "/api/convert?standalone=true", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		return r
+	}
+
+	dir, key := t.TempDir(), newJobKey()
+	form, up, err := readMultipartUpload(build([][2]string{{"from", "markdown"}}, "# Hi"), dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("from") != "markdown" || form.Get("to") != "html" || form.Get("standalone") != "true" {
+		t.Errorf("form = %v", form)
+	}
+	if up == nil || up.Filename != "doc.md" || readStored(t, up.Path, key) != "# Hi" {
+		t.Errorf("upload = %+v", up)
+	}
+
+	big := strings.Repeat("x", maxFormValue+1)
+	if _, _, err := readMultipartUpload(build([][2]string{{"note", big}}, ""), t.TempDir(), key); err == nil {
+		t.Error("oversized form field accepted")
+	}
+	tooLarge := strings.Repeat("x", 1<<20+1)
+	if _, _, err := readMultipartUpload(build(nil, tooLarge), t.TempDir(), key); err == nil {
+		t.Error("oversized file accepted")
+	} else if _, ok := asTooLarge(err); !ok {
+		t.Errorf("oversized file: %v is not a tooLargeError", err)
+	}
+}
+
+func TestUploadFilename(t *testing.T) {
+	tests := map[string]string{
+		"":                "upload",
+		".":               "upload",
+		"..":              "upload",
+		"/":               "upload",
+		"../notes.md":     "notes.md",
+		"dir/report.docx": "report.docx",
+	}
+	for in, want := range tests {
+		if got := uploadFilename(in); got != want {
+			t.Errorf("uploadFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestAsTooLarge(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.MaxUploadMB = 3
+		c.FormatLimitsMB = nil
+	})
+	r := httptest.NewRequest("POST", "/", strings.NewReader("0123456789"))
+	_, err := io.ReadAll(http.MaxBytesReader(httptest.NewRecorder(), r.Body, 4))
+	tl, ok := asTooLarge(err)
+	if !ok || tl.limit != 3<<20 {
+		t.Errorf("asTooLarge(%v) = %+v, %v", err, tl, ok)
+	}
+	if _, ok := asTooLarge(errors.New("other")); ok {
+		t.Error("unrelated error reported as too large")
+	}
+}
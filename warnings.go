@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Warning is a non-fatal problem reported during a conversion
+type Warning struct {
+	Type    string `json:"type"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// infoWarningTypes are INFO-level pandoc messages that still mean content
+// was lost and are worth surfacing
+var infoWarningTypes = map[string]bool{
+	"SkippedContent":    true,
+	"IgnoredElement":    true,
+	"NotRendered":       true,
+	"BlockNotRendered":  true,
+	"InlineNotRendered": true,
+}
+
+// readPandocLog parses the JSON log written by pandoc --log and returns
+// the warnings in it. Human-readable messages are taken from the matching
+// "[WARNING]" lines on stderr when they line up with the log entries.
+func readPandocLog(logPath, stderr string) []Warning {
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return nil
+	}
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil
+	}
+
+	var stderrMessages []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if msg, ok := strings.CutPrefix(line, "[WARNING] "); ok {
+			stderrMessages = append(stderrMessages, msg)
+		} else if n := len(stderrMessages); n > 0 && strings.HasPrefix(line, "  ") {
+			// Continuation of a multi-line warning
+			stderrMessages[n-1] += " " + strings.TrimSpace(line)
+		}
+	}
+
+	var warnings []Warning
+	warningIndex := 0
+	for _, e := range entries {
+		typ, _ := e["type"].(string)
+		level, _ := e["verbosity"].(string)
+		isWarning := level == "WARNING" || level == "ERROR"
+		if !isWarning && !infoWarningTypes[typ] {
+			continue
+		}
+
+		w := Warning{Type: typ, Level: strings.ToLower(level), Message: describeLogEntry(e)}
+		if level == "WARNING" {
+			if warningIndex < len(stderrMessages) {
+				w.Message = stderrMessages[warningIndex]
+			}
+			warningIndex++
+		}
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+// describeLogEntry renders a log entry's fields as a readable message
+func describeLogEntry(e map[string]interface{}) string {
+	var keys []string
+	for k := range e {
+		if k != "type" && k != "verbosity" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %v", k, e[k]))
+	}
+	typ, _ := e["type"].(string)
+	if len(parts) == 0 {
+		return typ
+	}
+	return typ + " (" + strings.Join(parts, ", ") + ")"
+}
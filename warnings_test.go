@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadPandocLog(t *testing.T) {
+	const log = `[
+  {"type": "CouldNotFetchResource", "verbosity": "WARNING", "resource": "a.png", "message": "not found"},
+  {"type": "ParsingTrace", "verbosity": "INFO", "position": "line 1"},
+  {"type": "SkippedContent", "verbosity": "INFO", "contents": "\\foo", "source": "line 3"},
+  {"type": "DuplicateIdentifier", "verbosity": "WARNING", "contents": "intro"},
+  {"type": "CouldNotConvertImage", "verbosity": "ERROR", "path": "b.svg"},
+  {"type": "MissingCharacter", "verbosity": "WARNING", "contents": "😀"}
+]`
+	const stderr = "[WARNING] Could not fetch resource a.png: not found\n" +
+		"[WARNING] Duplicate identifier 'intro'\n" +
+		"  Consider using --file-scope\n" +
+		"[INFO] unrelated\n"
+
+	path := filepath.Join(t.TempDir(), "pandoc.log")
+	if err := os.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got := readPandocLog(path, stderr)
+	want := []Warning{
+		{Type: "CouldNotFetchResource", Level: "warning", Message: "Could not fetch resource a.png: not found"},
+		{Type: "SkippedContent", Level: "info", Message: `SkippedContent (contents: \foo, source: line 3)`},
+		{Type: "DuplicateIdentifier", Level: "warning", Message: "Duplicate identifier 'intro' Consider using --file-scope"},
+		{Type: "CouldNotConvertImage", Level: "error", Message: "CouldNotConvertImage (path: b.svg)"},
+		// More log warnings than stderr lines fall back to the log fields
+		{Type: "MissingCharacter", Level: "warning", Message: "MissingCharacter (contents: 😀)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readPandocLog =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestReadPandocLogUnusable(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"empty":      "",
+		"empty list": "[]",
+		"malformed":  `[{"type": "SkippedContent", "verbosity":`,
+		"not a list": `{"type": "SkippedContent", "verbosity": "WARNING"}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name+".log")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got := readPandocLog(path, "[WARNING] something\n"); got != nil {
+			t.Errorf("%s log: readPandocLog = %+v, want none", name, got)
+		}
+	}
+	if got := readPandocLog(filepath.Join(dir, "missing.log"), "[WARNING] something\n"); got != nil {
+		t.Errorf("missing log: readPandocLog = %+v, want none", got)
+	}
+}
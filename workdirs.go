@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each job owns a directory under the work root, holding its files
+// encrypted with the job key:
+//
+//	<work_dir>/<job id>/input.<ext>   uploaded input, until the job runs
+//	<work_dir>/<job id>/output.<ext>  conversion result
+//
+// Converters run in a separate scratch directory with the decrypted input,
+// removed as soon as the conversion ends:
+//
+//	<scratch_dir>/convertly-run-*/    converter working directory, HOME and LibreOffice profile
+//
+// Directories are removed by renaming them to a trash name first, so a
+// partly deleted job directory is never mistaken for a live one.
+
+const trashPrefix = ".trash-"
+
+// jobDirPath returns the directory of a job
+func jobDirPath(id string) string {
+	return filepath.Join(cfg().WorkDir, id)
+}
+
+// createJobDir creates the directory of a new job
+func createJobDir(id string) (string, error) {
+	if err := os.MkdirAll(cfg().WorkDir, 0700); err != nil {
+		return "", err
+	}
+	dir := jobDirPath(id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// removeJobDir deletes a job's directory and everything in it
+func removeJobDir(id string) {
+	removeWorkEntry(id)
+}
+
+// removeWorkEntry atomically moves an entry of the work root out of the
+// way, then deletes it
+func removeWorkEntry(name string) {
+	path := filepath.Join(cfg().WorkDir, name)
+	trash := path
+	if !strings.HasPrefix(name, trashPrefix) {
+		trash = filepath.Join(cfg().WorkDir, trashPrefix+name)
+		if err := os.Rename(path, trash); err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Failed to remove %s: %v", path, err)
+			}
+			return
+		}
+	}
+	if err := os.RemoveAll(trash); err != nil {
+		log.Printf("Failed to remove %s: %v", trash, err)
+	}
+}
+
+// sweepWorkDir removes everything under the work root that does not
+// belong to a known job or upload, such as directories left by a previous
+// process
+func sweepWorkDir() {
+	entries, err := os.ReadDir(cfg().WorkDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to sweep work directory: %v", err)
+		}
+		return
+	}
+
+	jobStore.RLock()
+	uploadStore.RLock()
+	var stale []string
+	for _, e := range entries {
+		_, isJob := jobStore.jobs[e.Name()]
+		_, isUpload := uploadStore.uploads[e.Name()]
+		if !isJob && !isUpload {
+			stale = append(stale, e.Name())
+		}
+	}
+	uploadStore.RUnlock()
+	jobStore.RUnlock()
+
+	for _, name := range stale {
+		removeWorkEntry(name)
+	}
+	if len(stale) > 0 {
+		log.Printf("Swept %d stale entries from %s", len(stale), cfg().WorkDir)
+	}
+	sweepScratchDir()
+}
+
+// scratchPrefix names the plaintext work directories of running
+// conversions under scratch_dir
+const scratchPrefix = "convertly-run-"
+
+// sweepScratchDir removes work directories a crashed process left behind,
+// the only place plaintext can outlive a conversion
+func sweepScratchDir() {
+	stale, _ := filepath.Glob(filepath.Join(cfg().ScratchDir, scratchPrefix+"*"))
+	for _, path := range stale {
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Failed to remove %s: %v", path, err)
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("Swept %d stale work directories from %s", len(stale), cfg().ScratchDir)
+	}
+}
+
+// dirSize returns the total size of the files under path
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// diskUsageTTL is how long a walk of the work root is reused, so busy
+// servers do not walk it on every request
+const diskUsageTTL = 5 * time.Second
+
+// diskUsage caches the size of the work root
+var diskUsage struct {
+	sync.Mutex
+	bytes  int64
+	walked time.Time
+}
+
+// workDirUsage returns the size of the work root, walking it at most once
+// per diskUsageTTL
+func workDirUsage() int64 {
+	diskUsage.Lock()
+	defer diskUsage.Unlock()
+	if time.Since(diskUsage.walked) > diskUsageTTL {
+		diskUsage.bytes = dirSize(cfg().WorkDir)
+		diskUsage.walked = time.Now()
+	}
+	return diskUsage.bytes
+}
+
+// enforceDiskQuota evicts finished jobs, oldest first, until the work root
+// fits in the disk quota. It reports whether usage is within the quota.
+// Sizes are measured without holding the job store lock.
+func enforceDiskQuota() bool {
+	quota := int64(cfg().DiskQuotaMB) << 20
+	if quota <= 0 {
+		return true
+	}
+	usage := workDirUsage()
+	if usage <= quota {
+		return true
+	}
+
+	type candidate struct {
+		id      string
+		created time.Time
+	}
+	jobStore.RLock()
+	var finished []candidate
+	for id, entry := range jobStore.jobs {
+		if entry.Status == StatusDone || entry.Status == StatusFailed {
+			finished = append(finished, candidate{id, entry.CreatedAt})
+		}
+	}
+	jobStore.RUnlock()
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].created.Before(finished[j].created)
+	})
+
+	evicted := 0
+	for _, c := range finished {
+		if usage <= quota {
+			break
+		}
+		size := dirSize(jobDirPath(c.id))
+
+		// The job may have been removed since the snapshot
+		jobStore.Lock()
+		entry, ok := jobStore.jobs[c.id]
+		if ok {
+			shredKey(entry.Key)
+			delete(jobStore.jobs, c.id)
+		}
+		jobStore.Unlock()
+		if !ok {
+			continue
+		}
+		removeJobDir(c.id)
+		usage -= size
+		evicted++
+	}
+
+	diskUsage.Lock()
+	diskUsage.bytes = usage
+	diskUsage.Unlock()
+	if evicted > 0 {
+		log.Printf("Disk quota: evicted %d finished jobs", evicted)
+	}
+	return usage <= quota
+}
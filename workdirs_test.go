@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withConfig runs a test with a modified copy of the current config
+func withConfig(t *testing.T, mutate func(*Config)) {
+	t.Helper()
+	old := config.Load()
+	c := *cfg()
+	mutate(&c)
+	config.Store(&c)
+	t.Cleanup(func() { config.Store(old) })
+}
+
+// addTestJob registers a job with a directory holding size bytes
+func addTestJob(t *testing.T, id string, status JobStatus, created time.Time, size int) *JobEntry {
+	t.Helper()
+	dir, err := createJobDir(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "output.bin"), make([]byte, size), 0600); err != nil {
+		t.Fatal(err)
+	}
+	entry := &JobEntry{Status: status, CreatedAt: created, Key: newJobKey()}
+	jobStore.Lock()
+	jobStore.jobs[id] = entry
+	jobStore.Unlock()
+	t.Cleanup(func() {
+		jobStore.Lock()
+		delete(jobStore.jobs, id)
+		jobStore.Unlock()
+	})
+	return entry
+}
+
+func TestEnforceDiskQuota(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.WorkDir = t.TempDir()
+		c.DiskQuotaMB = 1
+	})
+	diskUsage.walked = time.Time{}
+
+	now := time.Now()
+	oldest := addTestJob(t, "quota-oldest", StatusDone, now.Add(-3*time.Minute), 600<<10)
+	addTestJob(t, "quota-newer", StatusFailed, now.Add(-2*time.Minute), 600<<10)
+	addTestJob(t, "quota-queued", StatusQueued, now.Add(-4*time.Minute), 300<<10)
+
+	if !enforceDiskQuota() {
+		t.Fatal("usage still over quota after eviction")
+	}
+
+	jobStore.RLock()
+	_, hasOldest := jobStore.jobs["quota-oldest"]
+	_, hasNewer := jobStore.jobs["quota-newer"]
+	_, hasQueued := jobStore.jobs["quota-queued"]
+	jobStore.RUnlock()
+	if hasOldest || !hasNewer || !hasQueued {
+		t.Errorf("after eviction: oldest %v, newer %v, queued %v; want only oldest evicted", hasOldest, hasNewer, hasQueued)
+	}
+	if _, err := os.Stat(jobDirPath("quota-oldest")); !os.IsNotExist(err) {
+		t.Errorf("evicted job directory still exists: %v", err)
+	}
+	if !bytes.Equal(oldest.Key, make([]byte, len(oldest.Key))) {
+		t.Error("evicted job key was not shredded")
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0700)
+	os.WriteFile(filepath.Join(dir, "x"), make([]byte, 10), 0600)
+	os.WriteFile(filepath.Join(dir, "a", "b", "y"), make([]byte, 32), 0600)
+	if got := dirSize(dir); got != 42 {
+		t.Errorf("dirSize = %d, want 42", got)
+	}
+	if got := dirSize(filepath.Join(dir, "missing")); got != 0 {
+		t.Errorf("dirSize of a missing path = %d, want 0", got)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a non-blank line of a YAML document with comments removed
+type yamlLine struct {
+	num     int
+	indent  int
+	text    string
+	comment bool // a trailing comment was removed
+}
+
+// yamlScalar is a scalar as written. Scalars are typed only when decoded
+// into a field, so "12345" stays a string where a string is expected.
+type yamlScalar struct {
+	text    string
+	quoted  bool
+	comment bool // followed by a comment on its line
+}
+
+// parseYAML parses the subset of YAML used by config files: nested
+// mappings, block and flow sequences, and plain and quoted scalars.
+// Anchors, tags and multi-line strings are not supported.
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		if lead := raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]; strings.Contains(lead, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		text, comment := stripYAMLComment(strings.TrimLeft(raw, " "))
+		if text == "" || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text, comment: comment})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	v, err := p.node(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].num)
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a trailing # comment outside quotes and
+// reports whether there was one
+func stripYAMLComment(s string) (string, bool) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " "), true
+		}
+	}
+	return s, false
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// node parses the mapping or sequence starting at the current line
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	if isYAMLSeqItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	out := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent || isYAMLSeqItem(line.text) {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.num)
+		}
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+
+		if value != "" {
+			v, err := parseYAMLScalar(value, line.comment)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.num, err)
+			}
+			out[key] = v
+			continue
+		}
+
+		// Nested block, or null when nothing is indented below the key
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || (next.indent == indent && isYAMLSeqItem(next.text)) {
+				v, err := p.node(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				out[key] = v
+				continue
+			}
+		}
+		out[key] = nil
+	}
+	return out, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	out := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && !isYAMLSeqItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+
+		item := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		switch {
+		case item == "":
+			// Nested block below the dash
+			p.pos++
+			if p.pos >= len(p.lines) || p.lines[p.pos].indent <= indent {
+				out = append(out, nil)
+				continue
+			}
+			v, err := p.node(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+
+		case isYAMLMappingStart(item):
+			// "- key: value" opens a mapping indented past the dash
+			itemIndent := indent + len(line.text) - len(item)
+			p.lines[p.pos] = yamlLine{num: line.num, indent: itemIndent, text: item, comment: line.comment}
+			v, err := p.mapping(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+
+		default:
+			v, err := parseYAMLScalar(item, line.comment)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.num, err)
+			}
+			out = append(out, v)
+			p.pos++
+		}
+	}
+	return out, nil
+}
+
+func isYAMLMappingStart(text string) bool {
+	if text[0] == '"' || text[0] == '\'' || text[0] == '[' {
+		return false
+	}
+	_, _, ok := splitYAMLKey(text)
+	return ok
+}
+
+// splitYAMLKey splits "key: value" and "key:"
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false
+		}
+		i = len(text) - 1
+	}
+	key = strings.TrimSpace(text[:i])
+	if uq, err := unquoteYAML(key); err == nil {
+		key = uq
+	}
+	return key, strings.TrimSpace(text[i+1:]), key != ""
+}
+
+// parseYAMLScalar parses a scalar or a flow sequence like [a, b]
+func parseYAMLScalar(s string, comment bool) (interface{}, error) {
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence %q", s)
+		}
+		out := []interface{}{}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return out, nil
+		}
+		for _, item := range strings.Split(inner, ",") {
+			v, err := parseYAMLScalar(strings.TrimSpace(item), comment)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	if strings.HasPrefix(s, "{") {
+		return nil, fmt.Errorf("flow mappings are not supported")
+	}
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		text, err := unquoteYAML(s)
+		return yamlScalar{text: text, quoted: true, comment: comment}, err
+	}
+	if s == "null" || s == "Null" || s == "NULL" || s == "~" {
+		return nil, nil
+	}
+	return yamlScalar{text: s, comment: comment}, nil
+}
+
+// unquoteYAML decodes a double- or single-quoted scalar
+func unquoteYAML(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strconv.Unquote(s)
+	}
+	return "", fmt.Errorf("unterminated string %s", s)
+}
+
+// unmarshalYAML decodes a YAML document into v, a pointer to a struct
+// with json tags. Scalars are converted to the type of the field they
+// fill; the result is then decoded as JSON, so unknown keys are errors.
+func unmarshalYAML(data []byte, v interface{}) error {
+	doc, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+	typed, err := yamlTyped(doc, reflect.TypeOf(v).Elem(), "", false)
+	if err != nil {
+		return err
+	}
+	js, err := json.Marshal(typed)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// yamlTyped converts a parsed node to JSON-ready values of type t. Nodes
+// that do not fit t are passed on untyped for the JSON decoder to reject.
+// Secret fields must be quoted when a comment follows them, since " #"
+// inside an unquoted value would otherwise silently cut it short.
+func yamlTyped(node interface{}, t reflect.Type, path string, secret bool) (interface{}, error) {
+	switch n := node.(type) {
+	case yamlScalar:
+		if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+			return n.text, nil
+		}
+		switch t.Kind() {
+		case reflect.String:
+			if secret && n.comment && !n.quoted {
+				return nil, fmt.Errorf("%s: quote the value, or \" #\" in it starts a comment", path)
+			}
+			return n.text, nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v, err := strconv.ParseInt(n.text, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not an integer", path, n.text)
+			}
+			return v, nil
+		case reflect.Float32, reflect.Float64:
+			v, err := strconv.ParseFloat(n.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not a number", path, n.text)
+			}
+			return v, nil
+		case reflect.Bool:
+			switch strings.ToLower(n.text) {
+			case "true", "yes", "on":
+				return true, nil
+			case "false", "no", "off":
+				return false, nil
+			}
+			return nil, fmt.Errorf("%s: %q is not a boolean", path, n.text)
+		}
+		return n.text, nil
+
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return yamlTyped(n, reflect.TypeOf([]interface{}{}), path, false)
+		}
+		out := make([]interface{}, len(n))
+		for i, item := range n {
+			v, err := yamlTyped(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), false)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(n))
+		for key, item := range n {
+			elem, secret := reflect.TypeOf((*interface{})(nil)).Elem(), false
+			switch t.Kind() {
+			case reflect.Map:
+				elem = t.Elem()
+			case reflect.Struct:
+				if f, ok := jsonField(t, key); ok {
+					elem, secret = f.Type, f.Tag.Get("secret") == "true"
+				}
+			}
+			v, err := yamlTyped(item, elem, strings.TrimPrefix(path+"."+key, "."), secret)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = v
+		}
+		return out, nil
+	}
+	return node, nil
+}
+
+// jsonField finds the struct field decoded from key
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
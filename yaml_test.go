@@ -0,0 +1,166 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc := `
+# comment
+port: 8080
+name: "quoted # not a comment"   # trailing comment
+single: 'it''s'
+enabled: yes
+ratio: 0.5
+empty:
+list: [a, 2, "c"]
+pools:
+  light:
+    timeout: 30s
+engines:
+  - xelatex
+  - pdflatex
+items:
+  - name: one
+    size: 1
+  -
+    name: two
+`
+	got, err := parseYAML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := func(s string) yamlScalar { return yamlScalar{text: s} }
+	want := map[string]interface{}{
+		"port":    plain("8080"),
+		"name":    yamlScalar{text: "quoted # not a comment", quoted: true, comment: true},
+		"single":  yamlScalar{text: "it's", quoted: true},
+		"enabled": plain("yes"),
+		"ratio":   plain("0.5"),
+		"empty":   nil,
+		"list":    []interface{}{plain("a"), plain("2"), yamlScalar{text: "c", quoted: true}},
+		"pools":   map[string]interface{}{"light": map[string]interface{}{"timeout": plain("30s")}},
+		"engines": []interface{}{plain("xelatex"), plain("pdflatex")},
+		"items": []interface{}{
+			map[string]interface{}{"name": plain("one"), "size": plain("1")},
+			map[string]interface{}{"name": plain("two")},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseYAML =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"tab indent":      "pools:\n\tlight: 1\n",
+		"duplicate key":   "port: 1\nport: 2\n",
+		"bad indentation": "port: 1\n  extra: 2\n",
+		"not a mapping":   "just text\n",
+		"flow mapping":    "pools: {light: 1}\n",
+		"unterminated":    "name: \"open\n",
+		"open flow list":  "list: [a, b\n",
+	}
+	for name, doc := range tests {
+		if _, err := parseYAML([]byte(doc)); err == nil {
+			t.Errorf("%s: parseYAML accepted %q", name, doc)
+		}
+	}
+}
+
+func TestParseYAMLEmpty(t *testing.T) {
+	got, err := parseYAML([]byte("# only comments\n---\n"))
+	if err != nil || !reflect.DeepEqual(got, map[string]interface{}{}) {
+		t.Errorf("parseYAML = %#v, %v; want an empty mapping", got, err)
+	}
+}
+
+func TestStripYAMLComment(t *testing.T) {
+	tests := []struct {
+		in, want string
+		comment  bool
+	}{
+		{"a: b # c", "a: b", true},
+		{"# c", "", true},
+		{"url: a#b", "url: a#b", false},
+		{`a: "x # y" # z`, `a: "x # y"`, true},
+		{"a: 'x # y'", "a: 'x # y'", false},
+	}
+	for _, tt := range tests {
+		if got, comment := stripYAMLComment(tt.in); got != tt.want || comment != tt.comment {
+			t.Errorf("stripYAMLComment(%q) = %q, %v; want %q, %v", tt.in, got, comment, tt.want, tt.comment)
+		}
+	}
+}
+
+type yamlTestConfig struct {
+	Name    string            `json:"name"`
+	Token   string            `json:"token" secret:"true"`
+	Port    int               `json:"port"`
+	Ratio   float64           `json:"ratio"`
+	Enabled bool              `json:"enabled"`
+	Wait    Duration          `json:"wait"`
+	Tags    []string          `json:"tags"`
+	Limits  map[string]int    `json:"limits"`
+	Labels  map[string]string `json:"labels"`
+	Nested  struct {
+		ID string `json:"id"`
+	} `json:"nested"`
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	doc := `
+name: 12345
+token: "abc #def"
+port: 8080
+ratio: 0.25
+enabled: on
+wait: 90
+tags: [007, true, null]
+limits:
+  pdf: 64
+labels:
+  zip: 01234
+nested:
+  id: 1e3
+`
+	var got yamlTestConfig
+	if err := unmarshalYAML([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := yamlTestConfig{
+		Name: "12345", Token: "abc #def", Port: 8080, Ratio: 0.25, Enabled: true,
+		Wait: Duration(90 * time.Second), Tags: []string{"007", "true", ""},
+		Limits: map[string]int{"pdf": 64}, Labels: map[string]string{"zip": "01234"},
+	}
+	want.Nested.ID = "1e3"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unmarshalYAML =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestUnmarshalYAMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"port: eighty\n":        "port",
+		"enabled: maybe\n":      "enabled",
+		"limits:\n  pdf: big\n": "limits.pdf",
+		"token: abc #def\n":     "token",
+		"nmae: typo\n":          "nmae",
+		"nested: [1, 2]\n":      "nested",
+	}
+	for doc, key := range tests {
+		var c yamlTestConfig
+		if err := unmarshalYAML([]byte(doc), &c); err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("unmarshalYAML(%q) = %v, want an error naming %s", doc, err, key)
+		}
+	}
+
+	// " #" outside secrets is a comment, as YAML has it
+	var c yamlTestConfig
+	if err := unmarshalYAML([]byte("name: abc #def\n"), &c); err != nil || c.Name != "abc" {
+		t.Errorf("name = %q, %v; want abc", c.Name, err)
+	}
+}